@@ -1,20 +1,35 @@
 package main
 
 import (
+    "context"
     "log"
     "flag"
-    "bytes"
+    "fmt"
     "net/http"
     "io/ioutil"
+    "math"
     "runtime"
+    "strconv"
+    "strings"
     "os"
     "os/signal"
     "syscall"
-    "encoding/json"
+    "time"
     "text/template"
+    "encoding/json"
     "gopkg.in/yaml.v2"
     "gopkg.in/natefinch/lumberjack.v2"
-    "github.com/ltkh/adapter/internal/snmptrap"
+    "github.com/ltkh/adapter/internal/alertstate"
+    "github.com/ltkh/adapter/internal/breaker"
+    "github.com/ltkh/adapter/internal/dlq"
+    "github.com/ltkh/adapter/internal/inhibit"
+    "github.com/ltkh/adapter/internal/jsonpath"
+    "github.com/ltkh/adapter/internal/queue"
+    "github.com/ltkh/adapter/internal/retry"
+    "github.com/ltkh/adapter/internal/route"
+    "github.com/ltkh/adapter/internal/silence"
+    "github.com/ltkh/adapter/internal/soap"
+    "github.com/ltkh/adapter/internal/tracker"
     "github.com/ltkh/adapter/internal/webhook"
 )
 
@@ -25,18 +40,92 @@ var (
 type Config struct {
     Global           *Global            `yaml:"global" json:"global"`
     Receivers        []*Receiver        `yaml:"receivers,omitempty" json:"receivers,omitempty"`
+    // Routing trees, each served on the path of its root route.
+    Routes           []*route.Route     `yaml:"routes,omitempty" json:"routes,omitempty"`
+    // Alerts of stateful receivers muting other alerts while firing.
+    InhibitRules     []*inhibit.Rule    `yaml:"inhibit_rules,omitempty" json:"inhibit_rules,omitempty"`
 }
 
 type Global struct {
     ListenAddress    string             `yaml:"listen_address" json:"listen_address"`
+    // The admin api (/api/v1/...) is served on this address, on
+    // listen_address when empty.
+    AdminAddress     string             `yaml:"admin_address,omitempty" json:"admin_address,omitempty"`
+    // Bearer token required by the admin api, no authentication when empty.
+    AdminToken       string             `yaml:"admin_token,omitempty" json:"admin_token,omitempty"`
+    Queue            *QueueConfig       `yaml:"queue,omitempty" json:"queue,omitempty"`
+    CircuitBreaker   *BreakerConfig     `yaml:"circuit_breaker,omitempty" json:"circuit_breaker,omitempty"`
+    // Time given to queued deliveries on shutdown.
+    ShutdownGracePeriod string          `yaml:"shutdown_grace_period,omitempty" json:"shutdown_grace_period,omitempty"`
+    // Default template context of the receivers, legacy when empty.
+    TemplateContext   string            `yaml:"template_context,omitempty" json:"template_context,omitempty"`
+    // Request headers templates can read as .Headers, they are stored with
+    // queued deliveries. Content-Type, User-Agent, X-Request-Id and
+    // X-Forwarded-For when not set.
+    RequestHeaders    []string          `yaml:"request_headers,omitempty" json:"request_headers,omitempty"`
+    // Silences are kept in this file, in memory only when empty.
+    SilencesFile      string            `yaml:"silences_file,omitempty" json:"silences_file,omitempty"`
+    // Number of recent requests whose delivery state is kept for the status endpoint.
+    TrackedDeliveries int               `yaml:"tracked_deliveries,omitempty" json:"tracked_deliveries,omitempty"`
+    // Finally failed deliveries are kept here, they are only logged when empty.
+    DeadLetterDir    string             `yaml:"dead_letter_dir,omitempty" json:"dead_letter_dir,omitempty"`
+}
+
+// QueueConfig configures the delivery queue, deliveries are kept
+// in memory only when no directory is set.
+type QueueConfig struct {
+    Dir              string             `yaml:"dir,omitempty" json:"dir,omitempty"`
+    // Maximum number of pending deliveries.
+    MaxItems         int                `yaml:"max_items,omitempty" json:"max_items,omitempty"`
+    // Size of a write-ahead log segment in bytes.
+    SegmentSize      int64              `yaml:"segment_size,omitempty" json:"segment_size,omitempty"`
+    // Fsync policy: always, interval (default) or never.
+    Fsync            string             `yaml:"fsync,omitempty" json:"fsync,omitempty"`
+    FsyncInterval    string             `yaml:"fsync_interval,omitempty" json:"fsync_interval,omitempty"`
+    // Number of delivery workers of the shared pool.
+    Workers          int                `yaml:"workers,omitempty" json:"workers,omitempty"`
+    // Deliveries the shared pool accepts before requests are rejected with 429.
+    QueueDepth       int                `yaml:"queue_depth,omitempty" json:"queue_depth,omitempty"`
+    // Retry-After sent with 429 and 503 responses.
+    RetryAfter       string             `yaml:"retry_after,omitempty" json:"retry_after,omitempty"`
 }
 
 // Receiver configuration provides configuration on how to contact a receiver.
 type Receiver struct {
-    // A unique identifier for this receiver.
+    // A unique identifier for this receiver, also the request path unless
+    // the receiver is only reached through routes. Segments like {team}
+    // match any value, which templates read as .params.team.
     Path             string             `yaml:"path" json:"path"`
+    // Accepted request methods, any method when empty.
+    Methods          []string           `yaml:"methods,omitempty" json:"methods,omitempty"`
     SNMPTrapConfigs  []*SnmpTrapConfig  `yaml:"snmptrap_configs,omitempty" json:"snmptrap_configs,omitempty"`
     WebhookConfigs   []*WebhookConfig   `yaml:"webhook_configs,omitempty" json:"webhook_configs,omitempty"`
+    SOAPConfigs      []*SoapConfig      `yaml:"soap_configs,omitempty" json:"soap_configs,omitempty"`
+    FailoverConfigs  []*FailoverConfig  `yaml:"failover_configs,omitempty" json:"failover_configs,omitempty"`
+    // Outputs run one after another, later steps can read earlier results.
+    Pipeline         []*OutputConfig    `yaml:"pipeline,omitempty" json:"pipeline,omitempty"`
+    // Own worker pool of the receiver, the shared pool is used when zero.
+    Workers          int                `yaml:"workers,omitempty" json:"workers,omitempty"`
+    QueueDepth       int                `yaml:"queue_depth,omitempty" json:"queue_depth,omitempty"`
+    // Wait for the outputs and report their results to the caller,
+    // also requested with ?sync=1. Outputs still retried after
+    // sync_timeout are reported as pending with 202.
+    Sync             bool               `yaml:"sync,omitempty" json:"sync,omitempty"`
+    SyncTimeout      string             `yaml:"sync_timeout,omitempty" json:"sync_timeout,omitempty"`
+    // Default retry policy of the outputs and the pipeline.
+    Retry            *RetryConfig       `yaml:"retry,omitempty" json:"retry,omitempty"`
+    // Deliver each element selected by the JSONPath separately, the whole
+    // payload is available as .Parent. Outputs may set their own split_by.
+    SplitBy          string             `yaml:"split_by,omitempty" json:"split_by,omitempty"`
+    // Root of the templates: legacy (the request body) or request
+    // (.Body, .Headers, .Query, .Remote, .Path, .Params, .ReceivedAt).
+    TemplateContext  string             `yaml:"template_context,omitempty" json:"template_context,omitempty"`
+    // Acknowledge repeated requests without forwarding them.
+    Dedup            *DedupConfig       `yaml:"dedup,omitempty" json:"dedup,omitempty"`
+    // Forward only alert state changes and reminders.
+    State            *StateConfig       `yaml:"state,omitempty" json:"state,omitempty"`
+    // Collect events into groups and notify the outputs once per group.
+    Group            *GroupConfig       `yaml:"group,omitempty" json:"group,omitempty"`
     //EmailConfigs     []*EmailConfig     `yaml:"email_configs,omitempty" json:"email_configs,omitempty"`
     //PagerdutyConfigs []*PagerdutyConfig `yaml:"pagerduty_configs,omitempty" json:"pagerduty_configs,omitempty"`
     //SlackConfigs     []*SlackConfig     `yaml:"slack_configs,omitempty" json:"slack_configs,omitempty"`
@@ -46,22 +135,517 @@ type Receiver struct {
     //VictorOpsConfigs []*VictorOpsConfig `yaml:"victorops_configs,omitempty" json:"victorops_configs,omitempty"`
 }
 
+type DedupConfig struct {
+    // Template rendered like the output templates, e.g. '{{ .groupKey }}-{{ hash .alerts }}'.
+    // Requests with an Idempotency-Key header are deduplicated by the header.
+    Key              string             `yaml:"key,omitempty" json:"key,omitempty"`
+    // Repeats of a key within the window are dropped, 5m by default.
+    Window           string             `yaml:"window,omitempty" json:"window,omitempty"`
+    tmpl             *template.Template
+}
+
+// StateConfig remembers the state of alerts, requests that do not change
+// the state of their alert are acknowledged but not forwarded. A request is
+// one alert, e.g. an Alertmanager group, so receivers with state cannot use
+// split_by: its elements would all share the state of the request.
+type StateConfig struct {
+    // Template identifying the alert, e.g. '{{ .labels.alertname }}/{{ .labels.instance }}'.
+    Fingerprint      string             `yaml:"fingerprint" json:"fingerprint"`
+    // Template rendering the status, resolved (or ok) or firing, '{{ .status }}' by default.
+    Status           string             `yaml:"status,omitempty" json:"status,omitempty"`
+    // Forward firing alerts again after this time without a change, never when empty.
+    ReminderInterval string             `yaml:"reminder_interval,omitempty" json:"reminder_interval,omitempty"`
+    // Drop resolved notifications of alerts that are not known as firing.
+    SuppressUnknownResolved bool        `yaml:"suppress_unknown_resolved,omitempty" json:"suppress_unknown_resolved,omitempty"`
+    // Forget alerts not received for this long, 24h by default.
+    TTL              string             `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+    fingerprint      *template.Template
+    status           *template.Template
+    store            *alertstate.Store
+}
+
+// GroupConfig groups events like Alertmanager. Templates of grouped
+// receivers get .Group (the key) and .Events (the member events), or
+// .Body.Group and .Body.Events with template_context request. Grouped
+// receivers cannot use split_by.
+type GroupConfig struct {
+    // Templates whose rendered values form the group key, one group when empty.
+    By               []string           `yaml:"by,omitempty" json:"by,omitempty"`
+    // Template identifying an event within its group, the whole event by default.
+    // Repeated events replace the earlier one without notifying again.
+    EventKey         string             `yaml:"event_key,omitempty" json:"event_key,omitempty"`
+    // Delay of the first notification of a new group, 30s by default.
+    GroupWait        string             `yaml:"group_wait,omitempty" json:"group_wait,omitempty"`
+    // Delay between notifications when events were added, 5m by default.
+    GroupInterval    string             `yaml:"group_interval,omitempty" json:"group_interval,omitempty"`
+    // Resend an unchanged group after this time, 4h by default, never when 0s.
+    RepeatInterval   string             `yaml:"repeat_interval,omitempty" json:"repeat_interval,omitempty"`
+    // Events leave the group this long after they were last received, 1h by default.
+    EventTTL         string             `yaml:"event_ttl,omitempty" json:"event_ttl,omitempty"`
+    by               []*template.Template
+    eventKey         *template.Template
+}
+
+// OutputConfig wraps exactly one output so that outputs of different types
+// can be ordered and referenced by name.
+type OutputConfig struct {
+    Name             string             `yaml:"name" json:"name"`
+    Webhook          *WebhookConfig     `yaml:"webhook,omitempty" json:"webhook,omitempty"`
+    SNMPTrap         *SnmpTrapConfig    `yaml:"snmptrap,omitempty" json:"snmptrap,omitempty"`
+    SOAP             *SoapConfig        `yaml:"soap,omitempty" json:"soap,omitempty"`
+    Failover         *FailoverConfig    `yaml:"failover,omitempty" json:"failover,omitempty"`
+}
+
+// FailoverConfig tries its outputs in turn until one succeeds. The retry
+// policies of the outputs are ignored, the group is retried as a whole.
+type FailoverConfig struct {
+    // ordered (default) always starts with the first output,
+    // round_robin starts with the output after the one used last.
+    Strategy         string             `yaml:"strategy,omitempty" json:"strategy,omitempty"`
+    Outputs          []*OutputConfig    `yaml:"outputs" json:"outputs"`
+    // Works as split_by of WebhookConfig.
+    SplitBy          string             `yaml:"split_by,omitempty" json:"split_by,omitempty"`
+    Retry            *RetryConfig       `yaml:"retry,omitempty" json:"retry,omitempty"`
+    next             uint32
+}
+
 type SnmpTrapConfig struct {
     Addr             string             `yaml:"addr" json:"addr"`
     Community        string             `yaml:"community,omitempty" json:"community,omitempty"`
     Retries          uint               `yaml:"retries,omitempty" json:"retries,omitempty"`
+    // Send informs and wait for the acknowledgement instead of traps.
+    Inform           bool               `yaml:"inform,omitempty" json:"inform,omitempty"`
+    Timeout          string             `yaml:"timeout,omitempty" json:"timeout,omitempty"`
     OptionTemplates  []string           `yaml:"option_templates,omitempty" json:"option_templates,omitempty"`
+    // split_by, when and drop_if work as in WebhookConfig.
+    SplitBy          string             `yaml:"split_by,omitempty" json:"split_by,omitempty"`
+    When             *Condition         `yaml:"when,omitempty" json:"when,omitempty"`
+    DropIf           *Condition         `yaml:"drop_if,omitempty" json:"drop_if,omitempty"`
+    Retry            *RetryConfig       `yaml:"retry,omitempty" json:"retry,omitempty"`
     //Options   snmptrap.HandlerConfig    `yaml:"options,omitempty" json:"options,omitempty"`
 }
 
 type WebhookConfig struct {
     URL              string             `yaml:"url" json:"url"`
     Method           string             `yaml:"method" json:"method"`
+    // Format of the rendered body: json, xml or text (no validation when empty).
+    BodyFormat       string             `yaml:"body_format,omitempty" json:"body_format,omitempty"`
+    // Re-serialise json bodies without insignificant whitespace.
+    CompactBody      bool               `yaml:"compact_body,omitempty" json:"compact_body,omitempty"`
     OptionTemplates  []string           `yaml:"option_templates,omitempty" json:"option_templates,omitempty"`
+    // Structured body: every string leaf is a template, the tree is sent as json.
+    Body             interface{}        `yaml:"body,omitempty" json:"body,omitempty"`
+    // Success criteria for the response, any 2xx when empty.
+    Success          *webhook.ResponseCheck `yaml:"success,omitempty" json:"success,omitempty"`
+    // Collect deliveries and send them as one request, templates get .Batch.
+    Batch            *BatchConfig       `yaml:"batch,omitempty" json:"batch,omitempty"`
+    // Deliver each element selected by the JSONPath, e.g. $.alerts[*],
+    // separately, overrides split_by of the receiver.
+    SplitBy          string             `yaml:"split_by,omitempty" json:"split_by,omitempty"`
+    // Send only when the condition holds and skip when drop_if holds, a
+    // skipped delivery is not an error.
+    When             *Condition         `yaml:"when,omitempty" json:"when,omitempty"`
+    DropIf           *Condition         `yaml:"drop_if,omitempty" json:"drop_if,omitempty"`
+    Retry            *RetryConfig       `yaml:"retry,omitempty" json:"retry,omitempty"`
     //Options   snmptrap.HandlerConfig    `yaml:"options,omitempty" json:"options,omitempty"`
 }
 
+type BatchConfig struct {
+    // Send once this many deliveries are collected, 100 by default.
+    MaxItems         int                `yaml:"max_items,omitempty" json:"max_items,omitempty"`
+    // Send at the latest this long after the first delivery, 10s by default.
+    MaxWait          string             `yaml:"max_wait,omitempty" json:"max_wait,omitempty"`
+}
+
+type SoapConfig struct {
+    URL              string             `yaml:"url" json:"url"`
+    // SOAP version: 1.1 (default) or 1.2.
+    Version          string             `yaml:"version,omitempty" json:"version,omitempty"`
+    Action           string             `yaml:"action,omitempty" json:"action,omitempty"`
+    Timeout          string             `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+    // WS-Security UsernameToken, the header is omitted without username.
+    Username         string             `yaml:"username,omitempty" json:"username,omitempty"`
+    Password         string             `yaml:"password,omitempty" json:"password,omitempty"`
+    // Password type: text (default) or digest.
+    PasswordType     string             `yaml:"password_type,omitempty" json:"password_type,omitempty"`
+    // Lifetime of the WS-Security timestamp, e.g. 5m (no timestamp when empty).
+    TimestampTTL     string             `yaml:"timestamp_ttl,omitempty" json:"timestamp_ttl,omitempty"`
+    // Templates rendering the content of the soap:Body element, values are
+    // inserted as is and must be escaped with xml, e.g. {{ xml .title }}.
+    OptionTemplates  []string           `yaml:"option_templates,omitempty" json:"option_templates,omitempty"`
+    // split_by, when and drop_if work as in WebhookConfig.
+    SplitBy          string             `yaml:"split_by,omitempty" json:"split_by,omitempty"`
+    When             *Condition         `yaml:"when,omitempty" json:"when,omitempty"`
+    DropIf           *Condition         `yaml:"drop_if,omitempty" json:"drop_if,omitempty"`
+    Retry            *RetryConfig       `yaml:"retry,omitempty" json:"retry,omitempty"`
+}
+
+// BreakerConfig configures the circuit breakers kept per destination
+// (webhook and soap host, snmp address).
+type BreakerConfig struct {
+    // Consecutive failures that open the circuit.
+    FailureThreshold int                `yaml:"failure_threshold" json:"failure_threshold"`
+    // Time the circuit stays open before a probe is let through.
+    OpenPeriod       string             `yaml:"open_period,omitempty" json:"open_period,omitempty"`
+    // Successful probes that close the circuit again.
+    HalfOpenProbes   int                `yaml:"half_open_probes,omitempty" json:"half_open_probes,omitempty"`
+}
+
+// RetryConfig configures redelivery of failed outputs, retryable errors
+// are network errors, 5xx, 408 and 429 responses and failed response checks.
+type RetryConfig struct {
+    // Attempts including the first one, 5 when not set.
+    MaxAttempts      int                `yaml:"max_attempts,omitempty" json:"max_attempts,omitempty"`
+    InitialBackoff   string             `yaml:"initial_backoff,omitempty" json:"initial_backoff,omitempty"`
+    MaxBackoff       string             `yaml:"max_backoff,omitempty" json:"max_backoff,omitempty"`
+    // Random fraction (0..1) of the backoff added or subtracted.
+    Jitter           float64            `yaml:"jitter,omitempty" json:"jitter,omitempty"`
+    // No more retries once the alert is older than this.
+    MaxAge           string             `yaml:"max_age,omitempty" json:"max_age,omitempty"`
+}
+
+func (rc *RetryConfig) validate() error {
+    for _, d := range []string{rc.InitialBackoff, rc.MaxBackoff, rc.MaxAge} {
+        if d == "" {
+            continue
+        }
+        if _, err := time.ParseDuration(d); err != nil {
+            return fmt.Errorf("retry: %v", err)
+        }
+    }
+    if rc.Jitter < 0 || rc.Jitter > 1 {
+        return fmt.Errorf("retry: jitter must be between 0 and 1")
+    }
+    return nil
+}
+
+func (rc *RetryConfig) policy() retry.Policy {
+    if rc == nil {
+        return retry.Policy{MaxAttempts: 1}
+    }
+    p := retry.Policy{
+        MaxAttempts:    rc.MaxAttempts,
+        InitialBackoff: time.Second,
+        MaxBackoff:     5 * time.Minute,
+        Jitter:         rc.Jitter,
+    }
+    if p.MaxAttempts <= 0 {
+        p.MaxAttempts = 5
+    }
+    if d, err := time.ParseDuration(rc.InitialBackoff); err == nil {
+        p.InitialBackoff = d
+    }
+    if d, err := time.ParseDuration(rc.MaxBackoff); err == nil {
+        p.MaxBackoff = d
+    }
+    if d, err := time.ParseDuration(rc.MaxAge); err == nil {
+        p.MaxAge = d
+    }
+    return p
+}
+
+func (bc *BatchConfig) validate() error {
+    if bc.MaxItems <= 0 {
+        bc.MaxItems = 100
+    }
+    if bc.MaxWait == "" {
+        bc.MaxWait = "10s"
+    }
+    if _, err := time.ParseDuration(bc.MaxWait); err != nil {
+        return fmt.Errorf("invalid max_wait: %v", err)
+    }
+    return nil
+}
+
+func (fc *FailoverConfig) validate() error {
+    if fc == nil {
+        return nil
+    }
+    if fc.Strategy != "" && fc.Strategy != "ordered" && fc.Strategy != "round_robin" {
+        return fmt.Errorf("unknown failover strategy %q", fc.Strategy)
+    }
+    if len(fc.Outputs) == 0 {
+        return fmt.Errorf("failover without outputs")
+    }
+    for i, o := range fc.Outputs {
+        if o.outputs() != 1 {
+            return fmt.Errorf("failover output %s must define exactly one output", o.label(i))
+        }
+        if err := o.Failover.validate(); err != nil {
+            return err
+        }
+        if o.splitBy() != "" {
+            return fmt.Errorf("failover output %s: use split_by on the failover group", o.label(i))
+        }
+        if o.Webhook != nil && o.Webhook.Batch != nil {
+            return fmt.Errorf("failover output %s: batch is only supported in webhook_configs", o.label(i))
+        }
+    }
+    return nil
+}
+
+func (c *Config) validate() error {
+    if c.Global == nil {
+        c.Global = &Global{}
+    }
+    if c.Global.ShutdownGracePeriod == "" {
+        c.Global.ShutdownGracePeriod = "30s"
+    }
+    if _, err := time.ParseDuration(c.Global.ShutdownGracePeriod); err != nil {
+        return fmt.Errorf("invalid shutdown_grace_period: %v", err)
+    }
+    if c.Global.RequestHeaders == nil {
+        c.Global.RequestHeaders = append([]string{}, defaultRequestHeaders...)
+    }
+    for i, name := range c.Global.RequestHeaders {
+        c.Global.RequestHeaders[i] = http.CanonicalHeaderKey(name)
+    }
+    if c.Global.Queue == nil {
+        c.Global.Queue = &QueueConfig{}
+    }
+    if c.Global.Queue.Fsync == "" {
+        c.Global.Queue.Fsync = "interval"
+    }
+    if !queue.ValidFsync(c.Global.Queue.Fsync) {
+        return fmt.Errorf("queue: unknown fsync policy %q", c.Global.Queue.Fsync)
+    }
+    if c.Global.Queue.FsyncInterval != "" {
+        if _, err := time.ParseDuration(c.Global.Queue.FsyncInterval); err != nil {
+            return fmt.Errorf("queue: invalid fsync_interval: %v", err)
+        }
+    }
+    if cb := c.Global.CircuitBreaker; cb != nil && cb.OpenPeriod != "" {
+        if _, err := time.ParseDuration(cb.OpenPeriod); err != nil {
+            return fmt.Errorf("circuit_breaker: invalid open_period: %v", err)
+        }
+    }
+    if c.Global.Queue.Workers <= 0 {
+        c.Global.Queue.Workers = 10
+    }
+    if c.Global.Queue.MaxItems <= 0 {
+        c.Global.Queue.MaxItems = 10000
+    }
+    if c.Global.Queue.QueueDepth <= 0 {
+        c.Global.Queue.QueueDepth = c.Global.Queue.MaxItems
+    }
+    if c.Global.Queue.RetryAfter == "" {
+        c.Global.Queue.RetryAfter = "5s"
+    }
+    if _, err := time.ParseDuration(c.Global.Queue.RetryAfter); err != nil {
+        return fmt.Errorf("queue: invalid retry_after: %v", err)
+    }
+
+    // Queued deliveries, routes and alert states refer to receivers by path
+    known := map[string]bool{}
+    for _, receiver := range c.Receivers {
+        if known[receiver.Path] {
+            return fmt.Errorf("receiver %s: duplicate path, use several outputs in one receiver instead", receiver.Path)
+        }
+        known[receiver.Path] = true
+    }
+    for i, tree := range c.Routes {
+        if tree.Path == "" {
+            return fmt.Errorf("routes[%d]: root route without path", i)
+        }
+        if err := route.CheckPattern(tree.Path); err != nil {
+            return fmt.Errorf("routes[%d]: %v", i, err)
+        }
+        for j, m := range tree.Methods {
+            tree.Methods[j] = strings.ToUpper(m)
+        }
+        if err := tree.Compile(); err != nil {
+            return fmt.Errorf("routes[%d]: %v", i, err)
+        }
+        for _, name := range tree.Receivers() {
+            if !known[name] {
+                return fmt.Errorf("routes[%d]: unknown receiver %q", i, name)
+            }
+        }
+    }
+
+    stateful := false
+    for _, receiver := range c.Receivers {
+        if receiver.State != nil {
+            stateful = true
+        }
+    }
+    for i, rule := range c.InhibitRules {
+        if !stateful {
+            return fmt.Errorf("inhibit_rules[%d]: source alerts require a receiver with state", i)
+        }
+        if err := rule.Compile(); err != nil {
+            return fmt.Errorf("inhibit_rules[%d]: %v", i, err)
+        }
+    }
+
+    for _, receiver := range c.Receivers {
+        if err := route.CheckPattern(receiver.Path); err != nil {
+            return fmt.Errorf("receiver %s: %v", receiver.Path, err)
+        }
+        for i, m := range receiver.Methods {
+            receiver.Methods[i] = strings.ToUpper(m)
+        }
+        if receiver.SyncTimeout != "" {
+            if _, err := time.ParseDuration(receiver.SyncTimeout); err != nil {
+                return fmt.Errorf("receiver %s: invalid sync_timeout: %v", receiver.Path, err)
+            }
+        }
+        if receiver.TemplateContext == "" {
+            receiver.TemplateContext = c.Global.TemplateContext
+        }
+        if receiver.TemplateContext == "" {
+            receiver.TemplateContext = contextLegacy
+        }
+        if receiver.TemplateContext != contextLegacy && receiver.TemplateContext != contextRequest {
+            return fmt.Errorf("receiver %s: unknown template_context %q", receiver.Path, receiver.TemplateContext)
+        }
+        if receiver.State != nil {
+            if err := receiver.State.compile(); err != nil {
+                return fmt.Errorf("receiver %s: state: %v", receiver.Path, err)
+            }
+        }
+        if receiver.Group != nil {
+            if err := receiver.Group.compile(); err != nil {
+                return fmt.Errorf("receiver %s: group: %v", receiver.Path, err)
+            }
+        }
+        if receiver.Dedup != nil {
+            if err := receiver.Dedup.compile(); err != nil {
+                return fmt.Errorf("receiver %s: dedup: %v", receiver.Path, err)
+            }
+        }
+        if receiver.Workers > 0 && receiver.QueueDepth <= 0 {
+            receiver.QueueDepth = 100 * receiver.Workers
+        }
+        for _, t := range receiver.targets() {
+            if t.batched() {
+                if err := t.output.Webhook.Batch.validate(); err != nil {
+                    return fmt.Errorf("receiver %s %s: batch: %v", receiver.Path, t.key, err)
+                }
+                if t.splitBy() != "" {
+                    return fmt.Errorf("receiver %s %s: batch and split_by cannot be combined", receiver.Path, t.key)
+                }
+            }
+            // The notification of a group is not a payload of the receiver,
+            // its events would go through the split expression
+            if receiver.Group != nil && t.splitBy() != "" {
+                return fmt.Errorf("receiver %s %s: group and split_by cannot be combined", receiver.Path, t.key)
+            }
+            // The state is kept per request, all elements would share it
+            if receiver.State != nil && t.splitBy() != "" {
+                return fmt.Errorf("receiver %s %s: state and split_by cannot be combined", receiver.Path, t.key)
+            }
+            if expr := t.splitBy(); expr != "" {
+                if _, err := jsonpath.Compile(expr); err != nil {
+                    return fmt.Errorf("receiver %s %s: split_by: %v", receiver.Path, t.key, err)
+                }
+            }
+            if rc := t.retryConfig(); rc != nil {
+                if err := rc.validate(); err != nil {
+                    return fmt.Errorf("receiver %s %s: %v", receiver.Path, t.key, err)
+                }
+            }
+        }
+        var webhookConfigs []*WebhookConfig
+        var soapConfigs []*SoapConfig
+        names := map[string]bool{}
+        for _, step := range receiver.Pipeline {
+            if step.Name == "" {
+                return fmt.Errorf("receiver %s: pipeline step without name", receiver.Path)
+            }
+            if names[step.Name] {
+                return fmt.Errorf("receiver %s: duplicate pipeline step %q", receiver.Path, step.Name)
+            }
+            names[step.Name] = true
+            if step.outputs() != 1 {
+                return fmt.Errorf("receiver %s: step %q must define exactly one output", receiver.Path, step.Name)
+            }
+            if err := step.Failover.validate(); err != nil {
+                return fmt.Errorf("receiver %s: step %q: %v", receiver.Path, step.Name, err)
+            }
+            if step.splitBy() != "" {
+                return fmt.Errorf("receiver %s: step %q: use split_by on the receiver to split the pipeline", receiver.Path, step.Name)
+            }
+            if step.Webhook != nil && step.Webhook.Batch != nil {
+                return fmt.Errorf("receiver %s: step %q: batch is only supported in webhook_configs", receiver.Path, step.Name)
+            }
+        }
+        for i, group := range receiver.FailoverConfigs {
+            if err := group.validate(); err != nil {
+                return fmt.Errorf("receiver %s: failover_configs[%d]: %v", receiver.Path, i, err)
+            }
+        }
+        for _, o := range receiver.allOutputs() {
+            when, dropIf := o.conditions()
+            if err := when.compile(); err != nil {
+                return fmt.Errorf("receiver %s: when: %v", receiver.Path, err)
+            }
+            if err := dropIf.compile(); err != nil {
+                return fmt.Errorf("receiver %s: drop_if: %v", receiver.Path, err)
+            }
+            if o.Webhook != nil {
+                webhookConfigs = append(webhookConfigs, o.Webhook)
+            }
+            if o.SOAP != nil {
+                soapConfigs = append(soapConfigs, o.SOAP)
+            }
+        }
+        for _, rcConf := range soapConfigs {
+            if !soap.ValidVersion(rcConf.Version) {
+                return fmt.Errorf("receiver %s: unknown soap version %q", receiver.Path, rcConf.Version)
+            }
+            if rcConf.PasswordType != "" && rcConf.PasswordType != "text" && rcConf.PasswordType != "digest" {
+                return fmt.Errorf("receiver %s: unknown password_type %q", receiver.Path, rcConf.PasswordType)
+            }
+            if rcConf.TimestampTTL != "" {
+                if _, err := time.ParseDuration(rcConf.TimestampTTL); err != nil {
+                    return fmt.Errorf("receiver %s: invalid timestamp_ttl: %v", receiver.Path, err)
+                }
+            }
+        }
+        for _, rcConf := range webhookConfigs {
+            if !webhook.ValidFormat(rcConf.BodyFormat) {
+                return fmt.Errorf("receiver %s: unknown body_format %q", receiver.Path, rcConf.BodyFormat)
+            }
+            if rcConf.Success != nil {
+                if err := rcConf.Success.Compile(); err != nil {
+                    return fmt.Errorf("receiver %s: %v", receiver.Path, err)
+                }
+            }
+            if rcConf.Body != nil {
+                if len(rcConf.OptionTemplates) > 0 {
+                    return fmt.Errorf("receiver %s: body and option_templates are mutually exclusive", receiver.Path)
+                }
+                if rcConf.BodyFormat != "" && rcConf.BodyFormat != "json" {
+                    return fmt.Errorf("receiver %s: body is always sent as json", receiver.Path)
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// retryLater answers with code and the configured Retry-After.
+func retryLater(w http.ResponseWriter, code int) {
+    retryAfter, _ := time.ParseDuration(cfg.Global.Queue.RetryAfter)
+    w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+    w.WriteHeader(code)
+}
+
 func server(w http.ResponseWriter, r *http.Request) {
+
+    ep, allowed := resolve(r.URL.Path, r.Method)
+    if ep == nil {
+        if len(allowed) == 0 {
+            log.Printf("[warn] no receiver for %s %s", r.Method, r.URL.Path)
+            w.WriteHeader(404)
+            return
+        }
+        log.Printf("[warn] method %s not allowed - %s", r.Method, r.URL.Path)
+        w.Header().Set("Allow", strings.Join(allowed, ", "))
+        w.WriteHeader(405)
+        return
+    }
   
     //reading request body
     body, err := ioutil.ReadAll(r.Body)
@@ -79,82 +663,198 @@ func server(w http.ResponseWriter, r *http.Request) {
         return
     }
     
-    for _, receiver := range cfg.Receivers {
-        if r.URL.Path == receiver.Path {
-            for _, rcConf := range receiver.WebhookConfigs {
-                go func(rcConf *WebhookConfig, data interface{}){
-
-                    tmpl, err := template.ParseFiles(rcConf.OptionTemplates...)
-                    if err != nil {
-                        log.Printf("[error] %v - %v", err, rcConf.OptionTemplates)
-                        return
-                    }
-
-                    var buf bytes.Buffer
-                    defer buf.Reset()
-                    if err = tmpl.Execute(&buf, &data); err != nil {
-                        log.Printf("[error] %v - %v", err, rcConf.OptionTemplates)
-                        return
-                    }
-
-                    client := webhook.NewClient(&webhook.HTTPClient{})
-                    _, err = client.HttpRequest(rcConf.URL, buf.Bytes())
-                    if err != nil {
-                        log.Printf("[error] %v - %v", err, rcConf.OptionTemplates)
-                        return
-                    }
-                    
-                }(rcConf, data)
-            }
-            for _, rcConf := range receiver.SNMPTrapConfigs {
-                go func(rcConf *SnmpTrapConfig, data interface{}){
-
-                    conf := snmptrap.Config{
-                        Addr:      rcConf.Addr,
-                        Community: rcConf.Community,
-                        Retries:   1,
-                    }
-
-                    tmpl, err := template.ParseFiles(rcConf.OptionTemplates...)
-                    if err != nil {
-                        log.Printf("[error] %v - %v", err, rcConf.OptionTemplates)
-                        return
-                    }
-
-                    var buf bytes.Buffer
-                    defer buf.Reset()
-                    if err = tmpl.Execute(&buf, &data); err != nil {
-                        log.Printf("[error] %v - %v", err, rcConf.OptionTemplates)
-                        return
-                    }
-
-                    opts := &[]snmptrap.Options{}
-                    if err := yaml.UnmarshalStrict([]byte(buf.String()), opts); err != nil {
-                        log.Printf("[error] %v - %s", err, rcConf.OptionTemplates)
-                        return
-                    }
-
-                    if len(*opts) > 0 {
-                        snmp := snmptrap.NewService(conf)
-                        snmp.Open()
-                        for _, opt := range *opts {
-                            snmp.Trap(opt.TrapOid, opt.DataList)
-                        }
-                        snmp.Close()
-                    }
-                    
-                }(rcConf, data)
-            }
-        }
-    }
-
-    w.WriteHeader(204)
+    receivers := ep.route(data)
+
+    // Backpressure: reject the whole request while a worker pool is saturated
+    need := map[*pool]int{}
+    for _, receiver := range receivers {
+        need[poolFor(receiver.Path)] += len(receiver.queueTargets())
+    }
+    for p, n := range need {
+        if p.full(n) {
+            log.Printf("[warn] worker pool %s is full - %s", p.name, r.URL.Path)
+            retryLater(w, 429)
+            return
+        }
+    }
+
+    id := newID()
+    receivedAt := time.Now()
+    req := newRequestInfo(r, ep.params, cfg.Global.RequestHeaders)
+
+    // Deduplication: repeats are acknowledged with the id of the first request
+    var forward []*Receiver
+    var keys []string
+    first := ""
+    for _, receiver := range receivers {
+        key, err := receiver.dedupKey(r, receiver.templateData(data, req, receivedAt, nil))
+        if err != nil {
+            log.Printf("[error] dedup key %v - %s", err, r.URL.Path)
+        }
+        if key != "" {
+            if orig, ok := receiver.duplicate(key, id); ok {
+                log.Printf("[info] duplicate of %s dropped - %s", orig, r.URL.Path)
+                first = orig
+                continue
+            }
+            keys = append(keys, key)
+        }
+        forward = append(forward, receiver)
+    }
+    if len(forward) == 0 && first != "" {
+        w.Header().Set("X-Delivery-Id", first)
+        writeJSON(w, 202, map[string]interface{}{"id": first, "duplicate": true})
+        return
+    }
+
+    // Firing alerts of stateful receivers are sources of the inhibition
+    // rules, silenced ones included
+    var undo []func()
+    if inhibitor.Enabled() {
+        for _, receiver := range forward {
+            if receiver.State == nil {
+                continue
+            }
+            if restore := receiver.inhibitSource(receiver.templateData(data, req, receivedAt, nil), data, receivedAt); restore != nil {
+                undo = append(undo, restore)
+            }
+        }
+    }
+
+    // Silenced and inhibited receivers record the event without delivering
+    // it, stateful ones still record the alert state. Stateful receivers
+    // forward only state changes and reminders.
+    receivers, forward = forward, nil
+    muted := map[string]interface{}{}
+    for _, receiver := range receivers {
+        sil, silenced := silences.Match(receiver.Path, data)
+        var src *inhibit.Source
+        inhibited := false
+        if !silenced && inhibitor.Enabled() {
+            src, inhibited = receiver.inhibited(receiver.templateData(data, req, receivedAt, nil), data, receivedAt)
+        }
+
+        switch {
+            case silenced:
+                log.Printf("[info] silenced by %s - %s", sil.ID, r.URL.Path)
+                for _, t := range receiver.targets() {
+                    tracked.Silence(id, receivedAt, receiver.Path, t.key, sil.ID)
+                }
+                muted["silenced"] = true
+            case inhibited:
+                log.Printf("[info] inhibited by alert %q of %s - %s", src.Fingerprint, src.Receiver, r.URL.Path)
+                for _, t := range receiver.targets() {
+                    tracked.Inhibit(id, receivedAt, receiver.Path, t.key, src.Fingerprint)
+                }
+                muted["inhibited"] = true
+        }
+
+        if receiver.State == nil {
+            if !silenced && !inhibited {
+                forward = append(forward, receiver)
+            }
+            continue
+        }
+        tc := receiver.templateData(data, req, receivedAt, nil)
+        if silenced || inhibited {
+            if restore := receiver.record(tc, receivedAt); restore != nil {
+                undo = append(undo, restore)
+            }
+            continue
+        }
+        ok, restore := receiver.observe(tc, receivedAt)
+        if restore != nil {
+            undo = append(undo, restore)
+        }
+        if !ok {
+            for _, t := range receiver.targets() {
+                tracked.Update(id, receivedAt, receiver.Path, t.key, tracker.Skipped, nil)
+            }
+            continue
+        }
+        forward = append(forward, receiver)
+    }
+    if len(forward) == 0 {
+        w.Header().Set("X-Delivery-Id", id)
+        if len(muted) > 0 {
+            muted["id"] = id
+            writeJSON(w, 202, muted)
+        } else {
+            writeJSON(w, 202, map[string]interface{}{"id": id, "forwarded": false})
+        }
+        return
+    }
+
+    // Grouped receivers queue the event for their group, which is notified
+    // later, callers only wait for the other receivers
+    receivers = nil
+    for _, receiver := range forward {
+        if receiver.Group == nil {
+            receivers = append(receivers, receiver)
+        }
+    }
+
+    var sw *syncWait
+    if len(receivers) > 0 && syncRequested(r, receivers) {
+        sw = waitResults(id, receivers, receivedAt)
+    }
+
+    if err := enqueue(forward, id, data, req, receivedAt); err != nil {
+        log.Printf("[error] %v - %s", err, r.URL.Path)
+        if sw != nil {
+            sw.cancel()
+        }
+        for _, key := range keys {
+            seen.Remove(key)
+        }
+        for _, restore := range undo {
+            restore()
+        }
+        if err == queue.ErrFull {
+            retryLater(w, 503)
+        } else {
+            w.WriteHeader(500)
+        }
+        return
+    }
+
+    w.Header().Set("X-Delivery-Id", id)
+    if sw != nil {
+        sw.respond(w, syncTimeout(receivers))
+        return
+    }
+
+    writeJSON(w, 202, map[string]string{"id": id})
     return
 
 }
 
+func loadConfig(filename string) (*Config, error) {
+    content, err := ioutil.ReadFile(filename)
+    if err != nil {
+        return nil, err
+    }
+
+    c := &Config{}
+    if err := yaml.UnmarshalStrict(content, c); err != nil {
+        return nil, fmt.Errorf("parsing YAML file %v", err)
+    }
+    if err := c.validate(); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
 func main() {
 
+    // Subcommands
+    if len(os.Args) > 1 && os.Args[1] == "dlq" {
+        os.Exit(dlqCommand(os.Args[2:]))
+    }
+    if len(os.Args) > 1 && os.Args[1] == "silence" {
+        os.Exit(silenceCommand(os.Args[2:]))
+    }
+
     //limits the number of operating system threads
     runtime.GOMAXPROCS(runtime.NumCPU())
 
@@ -179,19 +879,78 @@ func main() {
     }
 
     // Loading configuration file
-    content, err := ioutil.ReadFile(*cfFile)
+    var err error
+    cfg, err = loadConfig(*cfFile)
     if err != nil {
         log.Fatalf("[error] %v", err)
     }
-    
-    cfg = &Config{}
-    if err := yaml.UnmarshalStrict(content, cfg); err != nil {
-        log.Fatalf("[error] parsing YAML file %v", err)
+
+    // Delivery states for the status endpoint
+    tracked = tracker.New(cfg.Global.TrackedDeliveries)
+
+    // Active source alerts of the inhibition rules
+    inhibitor = inhibit.New(cfg.InhibitRules)
+
+    // Silences, created through the api
+    silences, err = silence.Open(cfg.Global.SilencesFile)
+    if err != nil {
+        log.Fatalf("[error] %v", err)
+    }
+
+    // Circuit breakers per destination
+    if cb := cfg.Global.CircuitBreaker; cb != nil {
+        openPeriod, _ := time.ParseDuration(cb.OpenPeriod)
+        breakers = breaker.NewRegistry(breaker.Config{
+            FailureThreshold: cb.FailureThreshold,
+            OpenPeriod:       openPeriod,
+            HalfOpenProbes:   cb.HalfOpenProbes,
+        })
+    }
+
+    // Opening dead-letter store
+    if cfg.Global.DeadLetterDir != "" {
+        deadLetters, err = dlq.Open(cfg.Global.DeadLetterDir)
+        if err != nil {
+            log.Fatalf("[error] opening dead-letter store %v", err)
+        }
     }
     
+    // Opening delivery queue, pending deliveries are replayed
+    fsyncInterval, _ := time.ParseDuration(cfg.Global.Queue.FsyncInterval)
+    deliveries, err = queue.Open(queue.Config{
+        Dir:           cfg.Global.Queue.Dir,
+        MaxItems:      cfg.Global.Queue.MaxItems,
+        SegmentSize:   cfg.Global.Queue.SegmentSize,
+        Fsync:         cfg.Global.Queue.Fsync,
+        FsyncInterval: fsyncInterval,
+    })
+    if err != nil {
+        log.Fatalf("[error] opening queue %v", err)
+    }
+    if n := deliveries.Len(); n > 0 {
+        log.Printf("[info] replaying %d pending deliveries", n)
+    }
+    startPools(deliveries, cfg)
+
     // Enabled listen port
     http.HandleFunc("/", server)
-    go http.ListenAndServe(cfg.Global.ListenAddress, nil)
+    admin := adminHandler(cfg.Global.AdminToken)
+    if cfg.Global.AdminAddress == "" {
+        http.Handle("/api/v1/", admin)
+    } else {
+        // The admin api stays available while deliveries are drained
+        go func() {
+            if err := http.ListenAndServe(cfg.Global.AdminAddress, admin); err != nil {
+                log.Fatalf("[error] %v", err)
+            }
+        }()
+    }
+    srv := &http.Server{Addr: cfg.Global.ListenAddress}
+    go func() {
+        if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            log.Fatalf("[error] %v", err)
+        }
+    }()
 
     log.Print("[info] adapter started -_-")
     
@@ -200,11 +959,62 @@ func main() {
     signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
     // Daemon mode
-    for {
-        <- c
-        log.Print("[info] adapter stopped")
-        os.Exit(0)
+    <- c
+    os.Exit(shutdown(srv))
+
+}
+
+// shutdown stops accepting requests, lets queued deliveries finish within
+// the grace period and closes the queue. It returns the exit status,
+// 1 when deliveries were lost.
+func shutdown(srv *http.Server) int {
+    grace, _ := time.ParseDuration(cfg.Global.ShutdownGracePeriod)
+    log.Printf("[info] adapter stopping, grace period %v", grace)
+
+    ctx, cancel := context.WithTimeout(context.Background(), grace)
+    defer cancel()
+
+    if err := srv.Shutdown(ctx); err != nil {
+        log.Printf("[error] http shutdown %v", err)
+    }
+    flushGroups()
+
+    // Waiting for due deliveries, scheduled retries stay in the queue.
+    // Groups and batches are sent once nothing else is in progress.
+    ticker := time.NewTicker(100 * time.Millisecond)
+    defer ticker.Stop()
+drain:
+    for deliveries.Due() > 0 {
+        if deliveries.Ready() == 0 && idle() {
+            flushGroups()
+            flushBatches()
+        }
+        select {
+            case <-ctx.Done():
+                log.Print("[warn] grace period expired with deliveries in progress")
+                break drain
+            case <-ticker.C:
+        }
+    }
+    flushBatches()
+
+    remaining := deliveries.Len()
+    status := 0
+    if err := deliveries.Close(); err != nil {
+        log.Printf("[error] closing queue %v", err)
+        status = 1
+    }
+
+    switch {
+        case remaining == 0:
+        case deliveries.Persistent() && status == 0:
+            log.Printf("[info] %d pending deliveries persisted for replay", remaining)
+        default:
+            log.Printf("[error] %d pending deliveries lost", remaining)
+            status = 1
     }
 
+    log.Print("[info] adapter stopped")
+    return status
 }
 
@@ -0,0 +1,289 @@
+package main
+
+import (
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+    "gopkg.in/yaml.v2"
+    "github.com/ltkh/adapter/internal/breaker"
+    "github.com/ltkh/adapter/internal/dlq"
+    "github.com/ltkh/adapter/internal/inhibit"
+    "github.com/ltkh/adapter/internal/queue"
+    "github.com/ltkh/adapter/internal/silence"
+    "github.com/ltkh/adapter/internal/tracker"
+)
+
+// testEndpoint is an http destination answering with the given status codes in
+// turn, the last one is repeated.
+type testEndpoint struct {
+    mu               sync.Mutex
+    codes            []int
+    bodies           []string
+    srv              *httptest.Server
+}
+
+func newTestEndpoint(t *testing.T, codes ...int) *testEndpoint {
+    e := &testEndpoint{codes: codes}
+    e.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := ioutil.ReadAll(r.Body)
+        e.mu.Lock()
+        e.bodies = append(e.bodies, string(body))
+        code := 200
+        if len(e.codes) > 0 {
+            code = e.codes[0]
+            if len(e.codes) > 1 {
+                e.codes = e.codes[1:]
+            }
+        }
+        e.mu.Unlock()
+        w.WriteHeader(code)
+        w.Write([]byte(`{"ok": true}`))
+    }))
+    t.Cleanup(e.srv.Close)
+    return e
+}
+
+func (e *testEndpoint) requests() []string {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    return append([]string{}, e.bodies...)
+}
+
+// setup loads the configuration and starts the delivery runtime like main,
+// the queue is kept in memory unless queue.dir is set. TMP in the
+// configuration is replaced by a temporary directory.
+func setup(t *testing.T, conf string) {
+    t.Helper()
+    dir, err := ioutil.TempDir("", "adapter")
+    if err != nil {
+        t.Fatal(err)
+    }
+    conf = strings.Replace(conf, "TMP", dir, -1)
+
+    c := &Config{}
+    if err := yaml.UnmarshalStrict([]byte(conf), c); err != nil {
+        t.Fatal(err)
+    }
+    if err := c.validate(); err != nil {
+        t.Fatal(err)
+    }
+    cfg = c
+
+    tracked = tracker.New(0)
+    inhibitor = inhibit.New(c.InhibitRules)
+    silences, _ = silence.Open("")
+    breakers = nil
+    if cb := c.Global.CircuitBreaker; cb != nil {
+        openPeriod, _ := time.ParseDuration(cb.OpenPeriod)
+        breakers = breaker.NewRegistry(breaker.Config{
+            FailureThreshold: cb.FailureThreshold,
+            OpenPeriod:       openPeriod,
+            HalfOpenProbes:   cb.HalfOpenProbes,
+        })
+    }
+    deadLetters = nil
+    if c.Global.DeadLetterDir != "" {
+        if deadLetters, err = dlq.Open(c.Global.DeadLetterDir); err != nil {
+            t.Fatal(err)
+        }
+    }
+    groups.m, groups.closed = map[string]*group{}, false
+    batches.m = map[string]*batch{}
+
+    start(t)
+
+    t.Cleanup(func() {
+        // Deliveries in progress would outlive the test
+        for deadline := time.Now().Add(5 * time.Second); !idle() && time.Now().Before(deadline); {
+            time.Sleep(10 * time.Millisecond)
+        }
+        groups.Lock()
+        groups.closed = true
+        for _, g := range groups.m {
+            if g.timer != nil {
+                g.timer.Stop()
+            }
+        }
+        groups.Unlock()
+        batches.Lock()
+        for _, b := range batches.m {
+            b.mu.Lock()
+            if b.timer != nil {
+                b.timer.Stop()
+            }
+            b.mu.Unlock()
+        }
+        batches.Unlock()
+        deliveries.Close()
+        os.RemoveAll(dir)
+    })
+}
+
+// start opens the queue of the configuration and starts the worker pools,
+// pending items of a persistent queue are replayed.
+func start(t *testing.T) {
+    t.Helper()
+    var err error
+    deliveries, err = queue.Open(queue.Config{
+        Dir:      cfg.Global.Queue.Dir,
+        MaxItems: cfg.Global.Queue.MaxItems,
+        Fsync:    cfg.Global.Queue.Fsync,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    startPools(deliveries, cfg)
+}
+
+// post sends a request to the server handler.
+func post(path, body string) *httptest.ResponseRecorder {
+    w := httptest.NewRecorder()
+    server(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+    return w
+}
+
+// waitFor polls cond for up to five seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+    t.Helper()
+    for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+        if cond() {
+            return
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    t.Fatalf("timed out waiting for %s", what)
+}
+
+// state returns the delivery state of one output of a request.
+func state(id, receiver, output string) string {
+    record, ok := tracked.Get(id)
+    if !ok {
+        return ""
+    }
+    for _, o := range record.Outputs {
+        if o.Receiver == receiver && o.Output == output {
+            return o.State
+        }
+    }
+    return ""
+}
+
+func TestServerRollback(t *testing.T) {
+    a := newTestEndpoint(t, 200)
+    b := newTestEndpoint(t, 200)
+    setup(t, `
+global:
+  listen_address: ':0'
+  queue:
+    max_items: 1
+    queue_depth: 10
+    retry_after: '3s'
+receivers:
+- path: '/a'
+  dedup:
+    key: '{{ .name }}'
+  state:
+    fingerprint: '{{ .name }}'
+  webhook_configs:
+    - url: '`+a.srv.URL+`'
+      body:
+        text: '{{ .name }}'
+    - url: '`+b.srv.URL+`'
+      body:
+        text: '{{ .name }}'
+`)
+    // Two deliveries never fit into the queue, every attempt is rejected
+    // as a whole and leaves nothing behind
+    for i := 0; i < 2; i++ {
+        w := post("/a?sync=1", `{"name": "x", "status": "firing"}`)
+        if w.Code != 503 || w.Header().Get("Retry-After") != "3" {
+            t.Fatalf("attempt %d: code %d, Retry-After %q", i, w.Code, w.Header().Get("Retry-After"))
+        }
+        if id := w.Header().Get("X-Delivery-Id"); id != "" {
+            t.Errorf("attempt %d: id %s of a rejected request", i, id)
+        }
+        if n := atomic.LoadInt64(&poolFor("/a").pending); n != 0 {
+            t.Errorf("attempt %d: %d deliveries reserved", i, n)
+        }
+        waiters.Lock()
+        if n := len(waiters.m); n != 0 {
+            t.Errorf("attempt %d: %d sync waiters", i, n)
+        }
+        waiters.Unlock()
+    }
+    if n := deliveries.Len(); n != 0 {
+        t.Errorf("%d queued deliveries", n)
+    }
+}
+
+func TestShutdown(t *testing.T) {
+    t.Run("drain", func(t *testing.T) {
+        ep := newTestEndpoint(t, 200)
+        setup(t, `
+global:
+  listen_address: ':0'
+receivers:
+- path: '/a'
+  webhook_configs:
+    - url: '`+ep.srv.URL+`'
+      body:
+        text: '{{ .name }}'
+`)
+        for i := 0; i < 5; i++ {
+            if w := post("/a", `{"name": "x"}`); w.Code != 202 {
+                t.Fatalf("code %d", w.Code)
+            }
+        }
+        // Due deliveries are sent before the queue is closed
+        if status := shutdown(&http.Server{}); status != 0 {
+            t.Errorf("exit status %d", status)
+        }
+        if n := len(ep.requests()); n != 5 {
+            t.Errorf("%d requests sent, want 5", n)
+        }
+    })
+
+    t.Run("scheduled retry", func(t *testing.T) {
+        ep := newTestEndpoint(t, 503, 200)
+        setup(t, `
+global:
+  listen_address: ':0'
+  queue:
+    dir: 'TMP/queue'
+receivers:
+- path: '/a'
+  retry:
+    max_attempts: 3
+    initial_backoff: '300ms'
+  webhook_configs:
+    - url: '`+ep.srv.URL+`'
+      body:
+        text: '{{ .name }}'
+`)
+        id := post("/a", `{"name": "x"}`).Header().Get("X-Delivery-Id")
+        waitFor(t, "retry", func() bool {
+            return state(id, "/a", "webhook_configs[0]") == tracker.Retrying
+        })
+
+        // The retry stays in the queue and is sent after a restart
+        if status := shutdown(&http.Server{}); status != 0 {
+            t.Errorf("exit status %d", status)
+        }
+        if n := len(ep.requests()); n != 1 {
+            t.Fatalf("%d requests sent before restart, want 1", n)
+        }
+        start(t)
+        waitFor(t, "replayed delivery", func() bool {
+            return state(id, "/a", "webhook_configs[0]") == tracker.Delivered && deliveries.Len() == 0
+        })
+        if n := len(ep.requests()); n != 2 {
+            t.Errorf("%d requests sent, want 2", n)
+        }
+    })
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+    "github.com/ltkh/adapter/internal/alertstate"
+    "github.com/ltkh/adapter/internal/silence"
+)
+
+// adminHandler serves the admin api, requests must carry the token as
+// "Authorization: Bearer <token>" unless it is empty.
+func adminHandler(token string) http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/api/v1/breakers", breakersHandler)
+    mux.HandleFunc("/api/v1/deliveries/", deliveryHandler)
+    mux.HandleFunc("/api/v1/alerts", alertsHandler)
+    mux.HandleFunc("/api/v1/inhibitions", inhibitionsHandler)
+    mux.HandleFunc("/api/v1/silences", silencesHandler)
+    mux.HandleFunc("/api/v1/silences/", silencesHandler)
+    if token == "" {
+        return mux
+    }
+
+    want := []byte("Bearer " + token)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+            w.Header().Set("WWW-Authenticate", "Bearer")
+            writeJSON(w, 401, map[string]string{"error": "unauthorized"})
+            return
+        }
+        mux.ServeHTTP(w, r)
+    })
+}
+
+// adminAddress returns the address the admin api is served on.
+func (g *Global) adminAddress() string {
+    if g.AdminAddress != "" {
+        return g.AdminAddress
+    }
+    return g.ListenAddress
+}
+
+// writeJSON sends v as a json response.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        log.Printf("[error] %v", err)
+    }
+}
+
+// breakersHandler reports the circuit breaker state of every destination.
+func breakersHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        w.WriteHeader(405)
+        return
+    }
+    writeJSON(w, 200, breakers.Status())
+}
+
+// deliveryHandler reports the state of every output of an accepted request,
+// GET /api/v1/deliveries/{id}.
+func deliveryHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        w.WriteHeader(405)
+        return
+    }
+    id := strings.TrimPrefix(r.URL.Path, "/api/v1/deliveries/")
+    record, ok := tracked.Get(id)
+    if !ok {
+        writeJSON(w, 404, map[string]string{"error": "delivery not found"})
+        return
+    }
+    writeJSON(w, 200, record)
+}
+
+// alertsHandler lists the alert states remembered by stateful receivers.
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        w.WriteHeader(405)
+        return
+    }
+    list := []alertstate.Alert{}
+    for _, receiver := range cfg.Receivers {
+        if receiver.State != nil {
+            list = append(list, receiver.State.store.List()...)
+        }
+    }
+    writeJSON(w, 200, list)
+}
+
+// inhibitionsHandler lists the firing alerts that inhibit others.
+func inhibitionsHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        w.WriteHeader(405)
+        return
+    }
+    writeJSON(w, 200, inhibitor.List(time.Now()))
+}
+
+// silencesHandler manages the silences,
+// GET and POST /api/v1/silences, GET and DELETE /api/v1/silences/{id}.
+func silencesHandler(w http.ResponseWriter, r *http.Request) {
+    id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/silences"), "/")
+
+    switch {
+        case id == "" && r.Method == http.MethodGet:
+            writeJSON(w, 200, silences.List())
+        case id == "" && r.Method == http.MethodPost:
+            sil := &silence.Silence{}
+            if err := json.NewDecoder(r.Body).Decode(sil); err != nil {
+                writeJSON(w, 400, map[string]string{"error": err.Error()})
+                return
+            }
+            if err := silences.Add(sil); err != nil {
+                writeJSON(w, 400, map[string]string{"error": err.Error()})
+                return
+            }
+            log.Printf("[info] silence %s created by %s - %v", sil.ID, sil.CreatedBy, sil.Matchers)
+            writeJSON(w, 201, map[string]string{"id": sil.ID})
+        case id != "" && r.Method == http.MethodGet:
+            sil, ok := silences.Get(id)
+            if !ok {
+                writeJSON(w, 404, map[string]string{"error": "silence not found"})
+                return
+            }
+            writeJSON(w, 200, sil)
+        case id != "" && r.Method == http.MethodDelete:
+            if _, ok := silences.Get(id); !ok {
+                writeJSON(w, 404, map[string]string{"error": "silence not found"})
+                return
+            }
+            sil, err := silences.Expire(id)
+            if err != nil {
+                writeJSON(w, 409, map[string]string{"error": err.Error()})
+                return
+            }
+            log.Printf("[info] silence %s expired", sil.ID)
+            writeJSON(w, 200, sil)
+        default:
+            w.WriteHeader(405)
+    }
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "github.com/ltkh/adapter/internal/queue"
+)
+
+// batches holds the open batch of every batched webhook by receiver and output.
+var batches = struct {
+    sync.Mutex
+    m map[string]*batch
+}{m: map[string]*batch{}}
+
+// batchContext is the root of the templates of batched webhooks, every
+// entry of Batch is what the template of a single delivery would get.
+type batchContext struct {
+    Batch            []interface{}
+}
+
+// batch collects the deliveries of a webhook until max_items are reached or
+// max_wait has passed since the first one. The queue items are acknowledged
+// once the batch was sent, so collected deliveries survive a crash.
+type batch struct {
+    mu               sync.Mutex
+    // held while a batch is sent, so that flushes do not overlap
+    sending          sync.Mutex
+    target           *target
+    q                *queue.Queue
+    jobs             []*job
+    timer            *time.Timer
+}
+
+func (t *target) batched() bool {
+    return t.output != nil && t.output.Webhook != nil && t.output.Webhook.Batch != nil
+}
+
+func batchFor(t *target) *batch {
+    key := t.receiver.Path + " " + t.key
+    batches.Lock()
+    defer batches.Unlock()
+
+    b, ok := batches.m[key]
+    if !ok {
+        b = &batch{target: t}
+        batches.m[key] = b
+    }
+    return b
+}
+
+func (b *batch) add(q *queue.Queue, item queue.Item, d *delivery) {
+    t := b.target
+    data := t.receiver.templateData(d.Data, d.Request, d.ReceivedAt, nil)
+    if skip, err := t.output.skips(data); err != nil {
+        finish(q, item, d, t, fmt.Errorf("%w - %v", err, t.output.templateNames()))
+        return
+    } else if skip {
+        finish(q, item, d, t, errSkipped)
+        return
+    }
+
+    conf := t.output.Webhook.Batch
+    b.mu.Lock()
+    b.q = q
+    b.jobs = append(b.jobs, &job{item: item, delivery: d})
+    if len(b.jobs) == 1 {
+        wait, _ := time.ParseDuration(conf.MaxWait)
+        b.timer = time.AfterFunc(wait, b.flush)
+    }
+    full := len(b.jobs) >= conf.MaxItems
+    b.mu.Unlock()
+
+    if full {
+        b.flush()
+    }
+}
+
+// flush sends the collected deliveries as one request.
+func (b *batch) flush() {
+    b.sending.Lock()
+    defer b.sending.Unlock()
+
+    b.mu.Lock()
+    jobs, q := b.jobs, b.q
+    b.jobs = nil
+    if b.timer != nil {
+        b.timer.Stop()
+        b.timer = nil
+    }
+    b.mu.Unlock()
+
+    if len(jobs) == 0 {
+        return
+    }
+
+    list := make([]*delivery, len(jobs))
+    for i, j := range jobs {
+        list[i] = j.delivery
+    }
+    err := b.target.sendBatch(list)
+    for _, j := range jobs {
+        finish(q, j.item, j.delivery, b.target, err)
+    }
+}
+
+// sendBatch renders the deliveries once as .Batch and sends them.
+func (t *target) sendBatch(list []*delivery) error {
+    ctx := &batchContext{}
+    for _, d := range list {
+        ctx.Batch = append(ctx.Batch, t.receiver.templateData(d.Data, d.Request, d.ReceivedAt, nil))
+    }
+    if _, err := t.output.Webhook.send(ctx); err != nil {
+        return fmt.Errorf("%w - %v", err, t.output.templateNames())
+    }
+    return nil
+}
+
+// flushBatches sends all open batches, waiting for flushes in progress.
+// It is called on shutdown once the queue is drained.
+func flushBatches() {
+    batches.Lock()
+    list := make([]*batch, 0, len(batches.m))
+    for _, b := range batches.m {
+        list = append(list, b)
+    }
+    batches.Unlock()
+
+    for _, b := range list {
+        b.flush()
+    }
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "testing"
+    "github.com/ltkh/adapter/internal/tracker"
+)
+
+func TestBatchFlush(t *testing.T) {
+    tests := []struct {
+        name     string
+        maxItems int
+        maxWait  string
+        posts    int
+        flush    bool
+        codes    []int
+        counts   []string
+        state    string
+    }{
+        {"max items", 2, "1h", 4, false, []int{200}, []string{"2", "2"}, tracker.Delivered},
+        {"max wait", 10, "100ms", 3, false, []int{200}, []string{"3"}, tracker.Delivered},
+        {"shutdown", 10, "1h", 3, true, []int{200}, []string{"3"}, tracker.Delivered},
+        // Every delivery of a failed batch fails
+        {"failed", 3, "1h", 3, false, []int{400}, []string{"3"}, tracker.Failed},
+    }
+    for _, tt := range tests {
+        ep := newTestEndpoint(t, tt.codes...)
+        setup(t, fmt.Sprintf(`
+global:
+  listen_address: ':0'
+receivers:
+- path: '/a'
+  webhook_configs:
+    - url: '%s'
+      batch:
+        max_items: %d
+        max_wait: '%s'
+      body:
+        count: '{{ number (len .Batch) }}'
+`, ep.srv.URL, tt.maxItems, tt.maxWait))
+
+        var ids []string
+        for i := 0; i < tt.posts; i++ {
+            ids = append(ids, post("/a", `{"name": "x"}`).Header().Get("X-Delivery-Id"))
+        }
+        if tt.flush {
+            waitFor(t, tt.name+" batch", func() bool {
+                b := batchFor(cfg.Receivers[0].targets()[0])
+                b.mu.Lock()
+                defer b.mu.Unlock()
+                return len(b.jobs) == tt.posts
+            })
+            if n := len(ep.requests()); n != 0 {
+                t.Errorf("%s: %d batches sent before max_wait", tt.name, n)
+            }
+            flushBatches()
+        }
+        for _, id := range ids {
+            waitFor(t, tt.name+" "+id, func() bool {
+                return state(id, "/a", "webhook_configs[0]") == tt.state
+            })
+        }
+        // Items are acknowledged once their batch was sent
+        waitFor(t, tt.name+" acknowledged", func() bool { return deliveries.Len() == 0 })
+
+        var counts []string
+        for _, body := range ep.requests() {
+            var msg struct{ Count json.Number }
+            if err := json.Unmarshal([]byte(body), &msg); err != nil {
+                t.Fatal(err)
+            }
+            counts = append(counts, msg.Count.String())
+        }
+        if fmt.Sprint(counts) != fmt.Sprint(tt.counts) {
+            t.Errorf("%s: batches %v, want %v", tt.name, counts, tt.counts)
+        }
+    }
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "net"
+    "net/http"
+    "time"
+)
+
+// Template context modes of a receiver.
+const (
+    // The parsed body is the root of templates ({{ .tag }}), path
+    // parameters are added as .params and pipeline results as .steps.
+    contextLegacy  = "legacy"
+    // Templates get a templateContext ({{ .Body.tag }}, {{ .Query.env }}).
+    contextRequest = "request"
+)
+
+// requestInfo is the metadata of the original request kept with every delivery.
+type requestInfo struct {
+    Path             string             `json:"path"`
+    Headers          map[string]string  `json:"headers,omitempty"`
+    Query            map[string]string  `json:"query,omitempty"`
+    Remote           string             `json:"remote,omitempty"`
+    Params           map[string]string  `json:"params,omitempty"`
+}
+
+// defaultRequestHeaders are kept when request_headers is not set, other
+// headers may carry credentials and are never written to the queue.
+var defaultRequestHeaders = []string{"Content-Type", "User-Agent", "X-Request-Id", "X-Forwarded-For"}
+
+// newRequestInfo keeps the first value of the listed headers and of every
+// query parameter.
+func newRequestInfo(r *http.Request, params map[string]string, headers []string) *requestInfo {
+    info := &requestInfo{
+        Path:    r.URL.Path,
+        Headers: map[string]string{},
+        Query:   map[string]string{},
+        Remote:  r.RemoteAddr,
+        Params:  params,
+    }
+    if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+        info.Remote = host
+    }
+    for _, name := range headers {
+        if values := r.Header[name]; len(values) > 0 {
+            info.Headers[name] = values[0]
+        }
+    }
+    for name, values := range r.URL.Query() {
+        if len(values) > 0 {
+            info.Query[name] = values[0]
+        }
+    }
+    return info
+}
+
+// templateContext is the root of templates in the request context mode.
+// Header names are canonical, e.g. {{ index .Headers "X-Env" }}.
+type templateContext struct {
+    Body             interface{}
+    Headers          map[string]string
+    Query            map[string]string
+    Remote           string
+    Path             string
+    Params           map[string]string
+    // Whole payload when the body is an element selected by split_by
+    Parent           interface{}
+    ReceivedAt       time.Time
+    // Results of earlier pipeline steps
+    Steps            map[string]interface{}
+}
+
+// templateData returns the root object of the receiver's templates, parent
+// is the whole payload when data is an element of it.
+func (receiver *Receiver) templateData(data interface{}, req *requestInfo, receivedAt time.Time, parent interface{}) interface{} {
+    if req == nil {
+        req = &requestInfo{}
+    }
+    if receiver.TemplateContext != contextRequest {
+        return withExtras(data, req.Params, parent)
+    }
+    return &templateContext{
+        Body:       data,
+        Headers:    req.Headers,
+        Query:      req.Query,
+        Remote:     req.Remote,
+        Path:       req.Path,
+        Params:     req.Params,
+        Parent:     parent,
+        ReceivedAt: receivedAt,
+    }
+}
+
+// withExtras exposes the path parameters to legacy templates as .params and
+// the parent of a split element as .Parent, the payload itself is not
+// modified. Only object payloads can carry them, payload fields with the
+// same names take precedence.
+func withExtras(data interface{}, params map[string]string, parent interface{}) interface{} {
+    obj, ok := data.(map[string]interface{})
+    if !ok || len(params) == 0 && parent == nil {
+        return data
+    }
+
+    ctx := make(map[string]interface{}, len(obj)+2)
+    for k, v := range obj {
+        ctx[k] = v
+    }
+    if _, ok := obj["params"]; !ok && len(params) > 0 {
+        p := make(map[string]interface{}, len(params))
+        for k, v := range params {
+            p[k] = v
+        }
+        ctx["params"] = p
+    }
+    if _, ok := obj["Parent"]; !ok && parent != nil {
+        ctx["Parent"] = parent
+    }
+    return ctx
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "text/template"
+    "time"
+    "github.com/ltkh/adapter/internal/dedup"
+)
+
+// seen holds the deduplication keys of all receivers.
+var seen = dedup.New()
+
+// keyFuncs are the helpers available in dedup and group key templates.
+var keyFuncs = template.FuncMap{
+    // hash returns the sha256 of the json encoding of v, e.g. of the alert set
+    "hash": func(v interface{}) (string, error) {
+        data, err := json.Marshal(v)
+        if err != nil {
+            return "", err
+        }
+        sum := sha256.Sum256(data)
+        return hex.EncodeToString(sum[:]), nil
+    },
+}
+
+func (dc *DedupConfig) compile() error {
+    if dc.Window == "" {
+        dc.Window = "5m"
+    }
+    if _, err := time.ParseDuration(dc.Window); err != nil {
+        return err
+    }
+    if dc.Key == "" {
+        return nil
+    }
+    tmpl, err := template.New("key").Funcs(keyFuncs).Option("missingkey=zero").Parse(dc.Key)
+    if err != nil {
+        return err
+    }
+    dc.tmpl = tmpl
+    return nil
+}
+
+// dedupKey returns the deduplication key of the request for receiver, empty
+// when the receiver does not deduplicate or the key renders empty. The
+// Idempotency-Key header takes precedence over the key template.
+func (receiver *Receiver) dedupKey(r *http.Request, data interface{}) (string, error) {
+    dc := receiver.Dedup
+    if dc == nil {
+        return "", nil
+    }
+
+    if key := r.Header.Get("Idempotency-Key"); key != "" {
+        return receiver.Path + " idempotency " + key, nil
+    }
+    if dc.tmpl == nil {
+        return "", nil
+    }
+
+    var buf bytes.Buffer
+    if err := dc.tmpl.Execute(&buf, &data); err != nil {
+        return "", err
+    }
+    if buf.Len() == 0 {
+        return "", nil
+    }
+    return receiver.Path + " key " + buf.String(), nil
+}
+
+// duplicate remembers the key of the request and returns the id of the
+// first request when it is a repeat within the window.
+func (receiver *Receiver) duplicate(key, id string) (string, bool) {
+    window, _ := time.ParseDuration(receiver.Dedup.Window)
+    return seen.Add(key, id, window)
+}
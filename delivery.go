@@ -0,0 +1,422 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "strconv"
+    "time"
+    "github.com/ltkh/adapter/internal/breaker"
+    "github.com/ltkh/adapter/internal/dlq"
+    "github.com/ltkh/adapter/internal/jsonpath"
+    "github.com/ltkh/adapter/internal/queue"
+    "github.com/ltkh/adapter/internal/retry"
+    "github.com/ltkh/adapter/internal/tracker"
+)
+
+var (
+    deliveries  *queue.Queue
+    deadLetters *dlq.Store
+    breakers    *breaker.Registry
+    tracked     *tracker.Store
+)
+
+// delivery is a single output delivery as stored in the queue.
+type delivery struct {
+    // ID of the accepted request, shared by all its outputs
+    ID               string             `json:"id"`
+    Receiver         string             `json:"receiver"`
+    Output           string             `json:"output"`
+    Data             interface{}        `json:"data"`
+    // Metadata of the original request
+    Request          *requestInfo       `json:"request,omitempty"`
+    // Elements of a split payload handled by earlier attempts
+    Done             []int              `json:"done,omitempty"`
+    // Results of the pipeline steps sent by earlier attempts for the
+    // payload or the current element, they are not sent again
+    Steps            map[string]interface{} `json:"steps,omitempty"`
+    ReceivedAt       time.Time          `json:"received_at"`
+    Attempts         []*attempt         `json:"attempts,omitempty"`
+}
+
+// attempt records a failed delivery attempt.
+type attempt struct {
+    Time             time.Time          `json:"time"`
+    Error            string             `json:"error"`
+}
+
+// target is one independently delivered output of a receiver: an entry
+// of the *_configs lists or the whole pipeline.
+type target struct {
+    key              string
+    receiver         *Receiver
+    output           *OutputConfig
+}
+
+// send delivers data to the output, steps holds the results of pipeline
+// steps that were already sent and receives the new ones.
+func (t *target) send(data interface{}, steps map[string]interface{}) error {
+    if t.output == nil {
+        return runPipeline(t.receiver, data, steps)
+    }
+    if _, err := t.output.send(data); err != nil {
+        return fmt.Errorf("%w - %v", err, t.output.templateNames())
+    }
+    return nil
+}
+
+// splitBy returns the JSONPath splitting the payload of the target, the
+// setting of the output takes precedence over the one of the receiver.
+func (t *target) splitBy() string {
+    if t.output != nil {
+        if path := t.output.splitBy(); path != "" {
+            return path
+        }
+    }
+    return t.receiver.SplitBy
+}
+
+// deliver sends the payload of d, or each element selected by split_by
+// with the payload as .Parent. Elements sent or skipped by an earlier
+// attempt are recorded in d.Done and not sent again, like the pipeline
+// steps in d.Steps.
+func (t *target) deliver(d *delivery) error {
+    if t.batched() {
+        return t.sendBatch([]*delivery{d})
+    }
+    if d.Steps == nil {
+        d.Steps = map[string]interface{}{}
+    }
+
+    expr := t.splitBy()
+    if expr == "" {
+        return t.send(t.receiver.templateData(d.Data, d.Request, d.ReceivedAt, nil), d.Steps)
+    }
+    path, err := jsonpath.Compile(expr)
+    if err != nil {
+        return err
+    }
+
+    done := map[int]bool{}
+    for _, i := range d.Done {
+        done[i] = true
+    }
+    sent := len(d.Done) > 0
+    for i, element := range path.Lookup(d.Data) {
+        if done[i] {
+            continue
+        }
+        err := t.send(t.receiver.templateData(element, d.Request, d.ReceivedAt, d.Data), d.Steps)
+        if err != nil && !errors.Is(err, errSkipped) {
+            return fmt.Errorf("element %d: %w", i, err)
+        }
+        if err == nil {
+            sent = true
+        }
+        d.Done = append(d.Done, i)
+        d.Steps = map[string]interface{}{}
+    }
+    if !sent {
+        return errSkipped
+    }
+    return nil
+}
+
+// retryConfig returns the retry policy of the output, falling back
+// to the policy of the receiver.
+func (t *target) retryConfig() *RetryConfig {
+    if t.output != nil {
+        if rc := t.output.retryConfig(); rc != nil {
+            return rc
+        }
+    }
+    return t.receiver.Retry
+}
+
+// targets returns the outputs of the receiver keyed by their position
+// in the configuration, e.g. webhook_configs[0].
+func (receiver *Receiver) targets() []*target {
+    var targets []*target
+    for i, rcConf := range receiver.WebhookConfigs {
+        targets = append(targets, &target{
+            key:      fmt.Sprintf("webhook_configs[%d]", i),
+            receiver: receiver,
+            output:   &OutputConfig{Webhook: rcConf},
+        })
+    }
+    for i, rcConf := range receiver.SNMPTrapConfigs {
+        targets = append(targets, &target{
+            key:      fmt.Sprintf("snmptrap_configs[%d]", i),
+            receiver: receiver,
+            output:   &OutputConfig{SNMPTrap: rcConf},
+        })
+    }
+    for i, rcConf := range receiver.SOAPConfigs {
+        targets = append(targets, &target{
+            key:      fmt.Sprintf("soap_configs[%d]", i),
+            receiver: receiver,
+            output:   &OutputConfig{SOAP: rcConf},
+        })
+    }
+    for i, group := range receiver.FailoverConfigs {
+        targets = append(targets, &target{
+            key:      fmt.Sprintf("failover_configs[%d]", i),
+            receiver: receiver,
+            output:   &OutputConfig{Failover: group},
+        })
+    }
+    if len(receiver.Pipeline) > 0 {
+        targets = append(targets, &target{key: "pipeline", receiver: receiver})
+    }
+    return targets
+}
+
+// findTarget returns the output of a queued delivery, receiver paths are
+// unique (see validate).
+func findTarget(path, key string) *target {
+    for _, receiver := range cfg.Receivers {
+        if receiver.Path != path {
+            continue
+        }
+        for _, t := range receiver.targets() {
+            if t.key == key {
+                return t
+            }
+        }
+    }
+    return nil
+}
+
+// newID returns a random identifier for an accepted request.
+func newID() string {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        return strconv.FormatInt(time.Now().UnixNano(), 36)
+    }
+    return hex.EncodeToString(b)
+}
+
+// groupOutput is the output of queued events of grouped receivers, they
+// are collected into the groups instead of being delivered.
+const groupOutput = "group"
+
+// queueTargets returns what a request queues for the receiver: one
+// delivery per output, or the event for its group.
+func (receiver *Receiver) queueTargets() []*target {
+    if receiver.Group != nil {
+        return []*target{{key: groupOutput, receiver: receiver}}
+    }
+    return receiver.targets()
+}
+
+// enqueue writes the deliveries of a request for the receivers to the
+// queue, all of them or none so that a rejected request can be sent again.
+func enqueue(receivers []*Receiver, id string, data interface{}, req *requestInfo, receivedAt time.Time) error {
+    var targets []*target
+    for _, receiver := range receivers {
+        targets = append(targets, receiver.queueTargets()...)
+    }
+    return enqueueTargets(targets, id, data, req, receivedAt)
+}
+
+// enqueueTargets writes one delivery per target, all of them or none.
+func enqueueTargets(targets []*target, id string, data interface{}, req *requestInfo, receivedAt time.Time) error {
+    var items [][]byte
+    var queued []*delivery
+    for _, t := range targets {
+        d := &delivery{
+            ID:         id,
+            Receiver:   t.receiver.Path,
+            Output:     t.key,
+            Data:       data,
+            Request:    req,
+            ReceivedAt: receivedAt,
+        }
+        item, err := json.Marshal(d)
+        if err != nil {
+            return err
+        }
+        items = append(items, item)
+        queued = append(queued, d)
+    }
+    // Counted and tracked before a worker can pick them up
+    for _, d := range queued {
+        poolFor(d.Receiver).reserve(d)
+        tracked.Update(id, receivedAt, d.Receiver, d.Output, tracker.Pending, nil)
+    }
+    if _, err := deliveries.PutAll(items); err != nil {
+        for _, d := range queued {
+            poolFor(d.Receiver).release(d)
+            tracked.Remove(id, d.Receiver, d.Output)
+        }
+        return err
+    }
+    return nil
+}
+
+func process(q *queue.Queue, item queue.Item, d *delivery) {
+    if d.Output == groupOutput {
+        for _, receiver := range cfg.Receivers {
+            if receiver.Path == d.Receiver && receiver.Group != nil {
+                collect(receiver, q, item, d)
+                return
+            }
+        }
+    }
+
+    // Events of receivers that are no longer grouped have no target either
+    t := findTarget(d.Receiver, d.Output)
+    if t == nil {
+        err := fmt.Errorf("output is no longer configured")
+        log.Printf("[error] queue item %d: output %s of receiver %s no longer configured", item.ID, d.Output, d.Receiver)
+        d.Attempts = append(d.Attempts, &attempt{Time: time.Now(), Error: err.Error()})
+        report(d, tracker.Failed, err)
+        if entry := deadLetter(d, err); entry != "" {
+            tracked.SetDeadLetter(d.ID, d.Receiver, d.Output, entry)
+        }
+        ack(q, item)
+        return
+    }
+
+    if t.batched() {
+        batchFor(t).add(q, item, d)
+        return
+    }
+    finish(q, item, d, t, t.deliver(d))
+}
+
+func ack(q *queue.Queue, item queue.Item) {
+    // After shutdown the item stays pending and is replayed on restart
+    if err := q.Ack(item.ID); err != nil && err != queue.ErrClosed {
+        log.Printf("[error] queue ack %v", err)
+    }
+}
+
+// finish records the outcome of a delivery attempt and acknowledges the
+// queue item, failed deliveries are requeued for a retry or dead-lettered.
+func finish(q *queue.Queue, item queue.Item, d *delivery, t *target, err error) {
+    defer ack(q, item)
+
+    if err == nil {
+        report(d, tracker.Delivered, nil)
+        return
+    }
+    if errors.Is(err, errSkipped) {
+        report(d, tracker.Skipped, nil)
+        return
+    }
+
+    // An open circuit breaker postpones the delivery, nothing was sent so
+    // no attempt is used up
+    var openErr *breaker.OpenError
+    postponed := errors.As(err, &openErr)
+    if !postponed {
+        d.Attempts = append(d.Attempts, &attempt{Time: time.Now(), Error: err.Error()})
+    }
+
+    policy := t.retryConfig().policy()
+    retryable, retryAfter := retry.Retryable(err)
+    if retryable {
+        delay := retryAfter
+        if !postponed && policy.Backoff(len(d.Attempts)) > delay {
+            delay = policy.Backoff(len(d.Attempts))
+        }
+        if policy.Allows(len(d.Attempts), d.ReceivedAt, delay) {
+            qerr := requeue(q, item, d, delay)
+            if qerr == queue.ErrClosed {
+                log.Printf("[warn] %v - %s %s (attempt %d, shutting down)", err, d.Receiver, d.Output, len(d.Attempts))
+                report(d, tracker.Pending, err)
+                return
+            }
+            if qerr != nil {
+                log.Printf("[error] requeue %v - %s %s", qerr, d.Receiver, d.Output)
+            } else if postponed {
+                log.Printf("[warn] %v - %s %s (postponed for %v)", err, d.Receiver, d.Output, delay)
+                report(d, tracker.Retrying, err)
+                return
+            } else {
+                log.Printf("[warn] %v - %s %s (attempt %d, retry in %v)", err, d.Receiver, d.Output, len(d.Attempts), delay)
+                report(d, tracker.Retrying, err)
+                return
+            }
+        }
+    }
+
+    if postponed {
+        d.Attempts = append(d.Attempts, &attempt{Time: time.Now(), Error: err.Error()})
+    }
+    log.Printf("[error] %v - %s %s (attempt %d, giving up)", err, d.Receiver, d.Output, len(d.Attempts))
+    report(d, tracker.Failed, err)
+    if entry := deadLetter(d, err); entry != "" {
+        tracked.SetDeadLetter(d.ID, d.Receiver, d.Output, entry)
+    }
+}
+
+// report records the state of the delivery for the status endpoint and
+// answers a synchronous caller once the delivery is finished.
+func report(d *delivery, state string, err error) {
+    var a *tracker.Attempt
+    if err != nil {
+        a = &tracker.Attempt{Time: time.Now(), Error: err.Error()}
+    }
+    tracked.Update(d.ID, d.ReceivedAt, d.Receiver, d.Output, state, a)
+
+    if state == tracker.Delivered || state == tracker.Skipped || state == tracker.Failed {
+        notify(d, state, err)
+    }
+}
+
+// deadLetter stores a finally failed delivery for inspection and replay
+// and returns the id of the entry.
+func deadLetter(d *delivery, err error) string {
+    if deadLetters == nil {
+        return ""
+    }
+
+    payload, merr := json.Marshal(d.Data)
+    if merr != nil {
+        log.Printf("[error] dead-letter %v - %s %s", merr, d.Receiver, d.Output)
+        return ""
+    }
+
+    e := &dlq.Entry{
+        Receiver:   d.Receiver,
+        Output:     d.Output,
+        Payload:    payload,
+        ReceivedAt: d.ReceivedAt,
+        FailedAt:   time.Now(),
+    }
+    if d.Request != nil {
+        e.Request, _ = json.Marshal(d.Request)
+    }
+    e.Done = d.Done
+    e.Steps = d.Steps
+    var serr *sendError
+    if errors.As(err, &serr) {
+        e.Rendered = string(serr.rendered)
+    }
+    for _, a := range d.Attempts {
+        e.Attempts = append(e.Attempts, dlq.Attempt{Time: a.Time, Error: a.Error})
+    }
+
+    if err := deadLetters.Add(e); err != nil {
+        log.Printf("[error] dead-letter %v - %s %s", err, d.Receiver, d.Output)
+        return ""
+    }
+    log.Printf("[info] dead-letter %s - %s %s", e.ID, d.Receiver, d.Output)
+    return e.ID
+}
+
+// requeue stores the delivery again in place of its queue item, it is
+// handed out after delay.
+func requeue(q *queue.Queue, item queue.Item, d *delivery, delay time.Duration) error {
+    data, err := json.Marshal(d)
+    if err != nil {
+        return err
+    }
+    _, err = q.Replace(item.ID, data, delay)
+    return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+    "github.com/ltkh/adapter/internal/tracker"
+)
+
+func TestPipelineResume(t *testing.T) {
+    tests := []struct {
+        name    string
+        notify  []int
+        state   string
+        tickets int
+        notices int
+    }{
+        {"first attempt", []int{200}, tracker.Delivered, 1, 1},
+        {"retried step", []int{503, 503, 200}, tracker.Delivered, 1, 3},
+        {"gives up", []int{503}, tracker.Failed, 1, 3},
+    }
+    for _, tt := range tests {
+        tickets := newTestEndpoint(t, 200)
+        notify := newTestEndpoint(t, tt.notify...)
+        setup(t, `
+global:
+  listen_address: ':0'
+receivers:
+- path: '/p'
+  retry:
+    max_attempts: 3
+    initial_backoff: '10ms'
+  pipeline:
+    - name: 'ticket'
+      webhook:
+        url: '`+tickets.srv.URL+`'
+        body:
+          t: '{{ .name }}'
+    - name: 'notify'
+      webhook:
+        url: '`+notify.srv.URL+`'
+        body:
+          t: '{{ .name }} {{ .steps.ticket.body.ok }}'
+`)
+        w := post("/p", `{"name": "a"}`)
+        var resp struct{ ID string }
+        json.Unmarshal(w.Body.Bytes(), &resp)
+        waitFor(t, tt.name, func() bool {
+            return state(resp.ID, "/p", "pipeline") == tt.state
+        })
+
+        if got := len(tickets.requests()); got != tt.tickets {
+            t.Errorf("%s: %d tickets, want %d", tt.name, got, tt.tickets)
+        }
+        notices := notify.requests()
+        if len(notices) != tt.notices {
+            t.Errorf("%s: %d notifications, want %d", tt.name, len(notices), tt.notices)
+        }
+        // Later attempts still see the result of the first step
+        for _, body := range notices {
+            if !strings.Contains(body, "a true") {
+                t.Errorf("%s: notification %s without step result", tt.name, body)
+            }
+        }
+    }
+}
+
+func TestFinish(t *testing.T) {
+    tests := []struct {
+        name     string
+        codes    []int
+        breaker  bool
+        state    string
+        requests int
+        attempts int
+        dead     bool
+    }{
+        {"delivered", []int{200}, false, tracker.Delivered, 1, 0, false},
+        {"requeued", []int{503, 503, 200}, false, tracker.Delivered, 3, 2, false},
+        {"not retryable", []int{400}, false, tracker.DeadLettered, 1, 1, true},
+        {"retries exhausted", []int{503}, false, tracker.DeadLettered, 3, 3, true},
+        // The open breaker postpones the retry without using up one of
+        // the two attempts
+        {"postponed", []int{503, 200}, true, tracker.Delivered, 2, 1, false},
+    }
+    for _, tt := range tests {
+        ep := newTestEndpoint(t, tt.codes...)
+        conf := `
+global:
+  listen_address: ':0'
+  dead_letter_dir: 'TMP/dlq'
+receivers:
+- path: '/a'
+  retry:
+    max_attempts: 3
+    initial_backoff: '10ms'
+  webhook_configs:
+    - url: '` + ep.srv.URL + `'
+      body:
+        text: '{{ .name }}'
+`
+        if tt.breaker {
+            conf = strings.Replace(conf, "global:", `global:
+  circuit_breaker:
+    failure_threshold: 1
+    open_period: '200ms'`, 1)
+            conf = strings.Replace(conf, "max_attempts: 3", "max_attempts: 2", 1)
+        }
+        setup(t, conf)
+
+        id := post("/a", `{"name": "x"}`).Header().Get("X-Delivery-Id")
+        waitFor(t, tt.name, func() bool {
+            return state(id, "/a", "webhook_configs[0]") == tt.state && deliveries.Len() == 0
+        })
+        if n := len(ep.requests()); n != tt.requests {
+            t.Errorf("%s: %d requests, want %d", tt.name, n, tt.requests)
+        }
+
+        entries, err := deadLetters.List()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if (len(entries) == 1) != tt.dead || len(entries) > 1 {
+            t.Fatalf("%s: %d dead letters", tt.name, len(entries))
+        }
+        if tt.dead && len(entries[0].Attempts) != tt.attempts {
+            t.Errorf("%s: %d attempts dead-lettered, want %d", tt.name, len(entries[0].Attempts), tt.attempts)
+        }
+        // Postponements are tracked like failed attempts
+        if !tt.dead && !tt.breaker {
+            record, _ := tracked.Get(id)
+            if n := len(record.Outputs[0].Attempts); n != tt.attempts {
+                t.Errorf("%s: %d failed attempts tracked, want %d", tt.name, n, tt.attempts)
+            }
+        }
+    }
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "text/tabwriter"
+    "time"
+    "github.com/ltkh/adapter/internal/dlq"
+)
+
+const dlqUsage = `usage: adapter dlq <command> [flags]
+
+commands:
+  list                   list dead-lettered deliveries
+  show <id>              print a dead-lettered delivery
+  replay [<id>...]       resend dead-lettered deliveries with the current config
+`
+
+// dlqCommand implements "adapter dlq ..." and returns the exit status.
+func dlqCommand(args []string) int {
+    if len(args) == 0 {
+        fmt.Fprint(os.Stderr, dlqUsage)
+        return 2
+    }
+
+    fs := flag.NewFlagSet("dlq "+args[0], flag.ContinueOnError)
+    cfFile   := fs.String("config", "", "config file")
+    receiver := fs.String("receiver", "", "only entries of this receiver path")
+    output   := fs.String("output", "", "only entries of this output, e.g. webhook_configs[0]")
+    if err := fs.Parse(args[1:]); err != nil {
+        return 2
+    }
+
+    var err error
+    cfg, err = loadConfig(*cfFile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "[error] %v\n", err)
+        return 1
+    }
+    if cfg.Global.DeadLetterDir == "" {
+        fmt.Fprintln(os.Stderr, "[error] dead_letter_dir is not configured")
+        return 1
+    }
+    store, err := dlq.Open(cfg.Global.DeadLetterDir)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "[error] %v\n", err)
+        return 1
+    }
+
+    switch args[0] {
+        case "list":
+            err = dlqList(store, *receiver, *output)
+        case "show":
+            if fs.NArg() != 1 {
+                fmt.Fprint(os.Stderr, dlqUsage)
+                return 2
+            }
+            err = dlqShow(store, fs.Arg(0))
+        case "replay":
+            err = dlqReplay(store, *receiver, *output, fs.Args())
+        default:
+            fmt.Fprint(os.Stderr, dlqUsage)
+            return 2
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "[error] %v\n", err)
+        return 1
+    }
+    return 0
+}
+
+// dlqSelect returns the entries matching the filters.
+func dlqSelect(store *dlq.Store, receiver, output string, ids []string) ([]*dlq.Entry, error) {
+    var entries []*dlq.Entry
+    if len(ids) > 0 {
+        for _, id := range ids {
+            e, err := store.Get(id)
+            if err != nil {
+                return nil, err
+            }
+            entries = append(entries, e)
+        }
+    } else {
+        var err error
+        if entries, err = store.List(); err != nil {
+            return nil, err
+        }
+    }
+
+    var selected []*dlq.Entry
+    for _, e := range entries {
+        if receiver != "" && e.Receiver != receiver {
+            continue
+        }
+        if output != "" && e.Output != output {
+            continue
+        }
+        selected = append(selected, e)
+    }
+    return selected, nil
+}
+
+func dlqList(store *dlq.Store, receiver, output string) error {
+    entries, err := dlqSelect(store, receiver, output, nil)
+    if err != nil {
+        return err
+    }
+
+    w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(w, "ID\tFAILED AT\tRECEIVER\tOUTPUT\tATTEMPTS\tLAST ERROR")
+    for _, e := range entries {
+        lastError := e.LastError()
+        if len(lastError) > 80 {
+            lastError = lastError[:77] + "..."
+        }
+        fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+            e.ID, e.FailedAt.Format(time.RFC3339), e.Receiver, e.Output, len(e.Attempts), lastError)
+    }
+    return w.Flush()
+}
+
+func dlqShow(store *dlq.Store, id string) error {
+    e, err := store.Get(id)
+    if err != nil {
+        return err
+    }
+    data, err := json.MarshalIndent(e, "", "  ")
+    if err != nil {
+        return err
+    }
+    fmt.Println(string(data))
+    return nil
+}
+
+// dlqReplay resends the entries once, delivered entries are removed and
+// failed ones are kept with the new attempt added.
+func dlqReplay(store *dlq.Store, receiver, output string, ids []string) error {
+    entries, err := dlqSelect(store, receiver, output, ids)
+    if err != nil {
+        return err
+    }
+
+    failed := 0
+    for _, e := range entries {
+        var data interface{}
+        if err := json.Unmarshal(e.Payload, &data); err != nil {
+            return fmt.Errorf("entry %s: %v", e.ID, err)
+        }
+
+        t := findTarget(e.Receiver, e.Output)
+        if t == nil {
+            failed++
+            fmt.Printf("%s\tskipped: output %s of receiver %s is not configured\n", e.ID, e.Output, e.Receiver)
+            continue
+        }
+
+        var req *requestInfo
+        if len(e.Request) > 0 {
+            if err := json.Unmarshal(e.Request, &req); err != nil {
+                return fmt.Errorf("entry %s: %v", e.ID, err)
+            }
+        }
+
+        d := &delivery{Data: data, Request: req, ReceivedAt: e.ReceivedAt, Done: e.Done, Steps: e.Steps}
+        err := t.deliver(d)
+        if err != nil && !errors.Is(err, errSkipped) {
+            failed++
+            e.Done = d.Done
+            e.Steps = d.Steps
+            e.Attempts = append(e.Attempts, dlq.Attempt{Time: time.Now(), Error: err.Error()})
+            e.FailedAt = time.Now()
+            if serr := store.Add(e); serr != nil {
+                return serr
+            }
+            fmt.Printf("%s\tfailed: %v\n", e.ID, err)
+            continue
+        }
+
+        if rerr := store.Remove(e.ID); rerr != nil {
+            return rerr
+        }
+        if err != nil {
+            fmt.Printf("%s\tskipped\n", e.ID)
+        } else {
+            fmt.Printf("%s\tdelivered\n", e.ID)
+        }
+    }
+
+    if failed > 0 {
+        return fmt.Errorf("%d of %d deliveries failed", failed, len(entries))
+    }
+    return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "strings"
+    "text/template"
+    "github.com/ltkh/adapter/internal/route"
+)
+
+// errSkipped is returned by outputs whose conditions reject the payload.
+var errSkipped = errors.New("skipped by output conditions")
+
+// Condition is either a template that renders true or false, or a list of
+// matchers on payload fields (see routes) that must all match.
+type Condition struct {
+    Template         string
+    Matchers         []string
+
+    tmpl             *template.Template
+    matchers         []*route.Matcher
+}
+
+func (c *Condition) UnmarshalYAML(unmarshal func(interface{}) error) error {
+    var list []string
+    if err := unmarshal(&list); err == nil {
+        c.Matchers = list
+        return nil
+    }
+    return unmarshal(&c.Template)
+}
+
+func (c *Condition) MarshalYAML() (interface{}, error) {
+    if c.Template != "" {
+        return c.Template, nil
+    }
+    return c.Matchers, nil
+}
+
+func (c *Condition) compile() error {
+    if c == nil {
+        return nil
+    }
+    if c.Template != "" {
+        tmpl, err := template.New("condition").Parse(c.Template)
+        if err != nil {
+            return err
+        }
+        c.tmpl = tmpl
+        return nil
+    }
+    if len(c.Matchers) == 0 {
+        return fmt.Errorf("empty condition")
+    }
+    c.matchers = nil
+    for _, s := range c.Matchers {
+        m, err := route.ParseMatcher(s)
+        if err != nil {
+            return err
+        }
+        c.matchers = append(c.matchers, m)
+    }
+    return nil
+}
+
+// holds evaluates the condition. Matchers look at the request body, also
+// in the request template context.
+func (c *Condition) holds(data interface{}) (bool, error) {
+    if c.tmpl != nil {
+        var buf bytes.Buffer
+        if err := c.tmpl.Execute(&buf, &data); err != nil {
+            return false, err
+        }
+        switch strings.TrimSpace(buf.String()) {
+            case "true":
+                return true, nil
+            case "false", "":
+                return false, nil
+        }
+        return false, fmt.Errorf("condition %q rendered %q, expected true or false", c.Template, buf.String())
+    }
+
+    doc := data
+    if tc, ok := data.(*templateContext); ok {
+        doc = tc.Body
+    }
+    for _, m := range c.matchers {
+        if !m.Matches(doc) {
+            return false, nil
+        }
+    }
+    return true, nil
+}
+
+// conditions returns the when and drop_if conditions of the wrapped output.
+func (o *OutputConfig) conditions() (*Condition, *Condition) {
+    switch {
+        case o.Webhook != nil:
+            return o.Webhook.When, o.Webhook.DropIf
+        case o.SNMPTrap != nil:
+            return o.SNMPTrap.When, o.SNMPTrap.DropIf
+        case o.SOAP != nil:
+            return o.SOAP.When, o.SOAP.DropIf
+    }
+    return nil, nil
+}
+
+// skips reports whether the output must not send data: when is false
+// or drop_if is true.
+func (o *OutputConfig) skips(data interface{}) (bool, error) {
+    when, dropIf := o.conditions()
+    if when != nil {
+        ok, err := when.holds(data)
+        if err != nil || !ok {
+            return true, err
+        }
+    }
+    if dropIf != nil {
+        return dropIf.holds(data)
+    }
+    return false, nil
+}
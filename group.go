@@ -0,0 +1,288 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "log"
+    "sort"
+    "strings"
+    "sync"
+    "text/template"
+    "time"
+    "github.com/ltkh/adapter/internal/queue"
+    "github.com/ltkh/adapter/internal/tracker"
+)
+
+// groups holds the active groups of all grouped receivers by receiver and
+// key, the lock protects the groups as well. The queue is never written
+// while it is held.
+var groups = struct {
+    sync.Mutex
+    m map[string]*group
+    // set on shutdown, groups stop notifying
+    closed bool
+}{m: map[string]*group{}}
+
+// group collects the events of a receiver that share a group key and
+// notifies the outputs with all of them: group_wait after the first event,
+// then at most every group_interval when events were added, and again
+// after repeat_interval without changes. Events leave the group event_ttl
+// after they were last received, the group ends with its last event.
+type group struct {
+    receiver         *Receiver
+    key              string
+    q                *queue.Queue
+    events           map[string]*groupEvent
+    changed          bool
+    // counts the events added, a notification only clears changed when
+    // no event was added while it was queued
+    version          int
+    notified         time.Time
+    timer            *time.Timer
+    // held while a notification is queued, so that flushes do not overlap
+    sending          sync.Mutex
+}
+
+type groupEvent struct {
+    data             interface{}
+    first            time.Time
+    last             time.Time
+    // queue item of an event that was not notified yet
+    item             *queue.Item
+}
+
+func (gc *GroupConfig) compile() error {
+    defaults := map[*string]string{
+        &gc.GroupWait:      "30s",
+        &gc.GroupInterval:  "5m",
+        &gc.RepeatInterval: "4h",
+        &gc.EventTTL:       "1h",
+    }
+    for field, value := range defaults {
+        if *field == "" {
+            *field = value
+        }
+        if _, err := time.ParseDuration(*field); err != nil {
+            return err
+        }
+    }
+
+    gc.by = nil
+    for _, s := range gc.By {
+        tmpl, err := template.New("by").Funcs(keyFuncs).Option("missingkey=zero").Parse(s)
+        if err != nil {
+            return err
+        }
+        gc.by = append(gc.by, tmpl)
+    }
+    if gc.EventKey != "" {
+        tmpl, err := template.New("event_key").Funcs(keyFuncs).Option("missingkey=zero").Parse(gc.EventKey)
+        if err != nil {
+            return err
+        }
+        gc.eventKey = tmpl
+    }
+    return nil
+}
+
+func (gc *GroupConfig) durations() (wait, interval, repeat, ttl time.Duration) {
+    wait, _ = time.ParseDuration(gc.GroupWait)
+    interval, _ = time.ParseDuration(gc.GroupInterval)
+    repeat, _ = time.ParseDuration(gc.RepeatInterval)
+    ttl, _ = time.ParseDuration(gc.EventTTL)
+    return
+}
+
+// renderKey executes the key templates and joins their results.
+func renderKey(list []*template.Template, data interface{}) (string, error) {
+    var parts []string
+    for _, tmpl := range list {
+        var buf bytes.Buffer
+        if err := tmpl.Execute(&buf, &data); err != nil {
+            return strings.Join(parts, ","), err
+        }
+        parts = append(parts, buf.String())
+    }
+    return strings.Join(parts, ","), nil
+}
+
+// collect adds a queued event to the group of its receiver, creating the
+// group with its first event. The queue item is acknowledged once the
+// event is part of a queued notification, so collected events survive a
+// crash.
+func collect(receiver *Receiver, q *queue.Queue, item queue.Item, d *delivery) {
+    gc := receiver.Group
+    ctx := receiver.templateData(d.Data, d.Request, d.ReceivedAt, nil)
+
+    key, err := renderKey(gc.by, ctx)
+    if err != nil {
+        log.Printf("[error] group key %v - %s", err, receiver.Path)
+    }
+
+    var eventKey string
+    if gc.eventKey != nil {
+        eventKey, err = renderKey([]*template.Template{gc.eventKey}, ctx)
+        if err != nil {
+            log.Printf("[error] group event key %v - %s", err, receiver.Path)
+        }
+    } else {
+        raw, _ := json.Marshal(d.Data)
+        eventKey = string(raw)
+    }
+    tracked.Update(d.ID, d.ReceivedAt, d.Receiver, d.Output, tracker.Grouped, nil)
+
+    groups.Lock()
+    g, ok := groups.m[receiver.Path+" "+key]
+    if !ok {
+        g = &group{receiver: receiver, key: key, q: q, events: map[string]*groupEvent{}}
+        groups.m[receiver.Path+" "+key] = g
+        if !groups.closed {
+            wait, _, _, _ := gc.durations()
+            g.timer = time.AfterFunc(wait, g.tick)
+        }
+    }
+
+    // An event that was already notified is only updated, its item is
+    // not needed. The item of an event still waiting is replaced.
+    var done *queue.Item
+    if e, ok := g.events[eventKey]; ok {
+        e.data = d.Data
+        e.last = d.ReceivedAt
+        done = &item
+        if e.item != nil {
+            done, e.item = e.item, &item
+        }
+    } else {
+        g.events[eventKey] = &groupEvent{data: d.Data, first: d.ReceivedAt, last: d.ReceivedAt, item: &item}
+        g.changed = true
+        g.version++
+    }
+    groups.Unlock()
+
+    if done != nil {
+        ack(q, *done)
+    }
+}
+
+// tick expires old events and notifies the group when it changed or the
+// repeat interval has passed.
+func (g *group) tick() {
+    _, interval, repeat, ttl := g.receiver.Group.durations()
+    now := time.Now()
+
+    g.sending.Lock()
+    defer g.sending.Unlock()
+
+    groups.Lock()
+    if groups.closed {
+        groups.Unlock()
+        return
+    }
+    var expired []queue.Item
+    for k, e := range g.events {
+        if now.Sub(e.last) > ttl {
+            if e.item != nil {
+                expired = append(expired, *e.item)
+            }
+            delete(g.events, k)
+        }
+    }
+    empty := len(g.events) == 0
+    if empty {
+        delete(groups.m, g.receiver.Path+" "+g.key)
+    }
+    send := !empty && (g.changed || repeat > 0 && now.Sub(g.notified) >= repeat)
+    groups.Unlock()
+
+    for _, item := range expired {
+        ack(g.q, item)
+    }
+    if empty {
+        return
+    }
+    if send {
+        g.notify(now)
+    }
+
+    groups.Lock()
+    if !groups.closed {
+        g.timer = time.AfterFunc(interval, g.tick)
+    }
+    groups.Unlock()
+}
+
+// notify queues a notification with all events of the group ordered by
+// their first occurrence. Templates get .Group (the key) and .Events,
+// .Body.Group and .Body.Events with template_context request.
+// Events added meanwhile keep the group changed, the caller holds
+// g.sending.
+func (g *group) notify(now time.Time) {
+    groups.Lock()
+    list := make([]*groupEvent, 0, len(g.events))
+    for _, e := range g.events {
+        list = append(list, e)
+    }
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].first.Before(list[j].first)
+    })
+    events := make([]interface{}, len(list))
+    items := make([]*queue.Item, len(list))
+    for i, e := range list {
+        events[i] = e.data
+        items[i] = e.item
+    }
+    version := g.version
+    groups.Unlock()
+
+    // The queue is written without holding the lock of all groups
+    data := map[string]interface{}{"Group": g.key, "Events": events}
+    if err := enqueueTargets(g.receiver.targets(), newID(), data, nil, now); err != nil {
+        log.Printf("[error] group %q: %v - %s", g.key, err, g.receiver.Path)
+        return
+    }
+
+    groups.Lock()
+    g.notified = now
+    if g.version == version {
+        g.changed = false
+    }
+    var done []queue.Item
+    for i, e := range list {
+        // Replaced items were acknowledged by collect
+        if items[i] != nil && e.item == items[i] {
+            done = append(done, *e.item)
+            e.item = nil
+        }
+    }
+    groups.Unlock()
+
+    for _, item := range done {
+        ack(g.q, item)
+    }
+}
+
+// flushGroups queues the pending notifications of all groups and stops
+// their timers, it is called on shutdown and while the queue is drained.
+func flushGroups() {
+    groups.Lock()
+    groups.closed = true
+    list := make([]*group, 0, len(groups.m))
+    for _, g := range groups.m {
+        if g.timer != nil {
+            g.timer.Stop()
+        }
+        list = append(list, g)
+    }
+    groups.Unlock()
+
+    for _, g := range list {
+        g.sending.Lock()
+        groups.Lock()
+        changed := g.changed
+        groups.Unlock()
+        if changed {
+            g.notify(time.Now())
+        }
+        g.sending.Unlock()
+    }
+}
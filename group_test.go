@@ -0,0 +1,104 @@
+package main
+
+import (
+    "encoding/json"
+    "sort"
+    "strings"
+    "testing"
+    "gopkg.in/yaml.v2"
+    "github.com/ltkh/adapter/internal/tracker"
+)
+
+func TestGroupNotify(t *testing.T) {
+    chat := newTestEndpoint(t, 200)
+    setup(t, `
+global:
+  listen_address: ':0'
+receivers:
+- path: '/z'
+  group:
+    by: ['{{ .host }}']
+    event_key: '{{ .trigger }}'
+    group_wait: '200ms'
+    group_interval: '1h'
+    repeat_interval: '0s'
+  webhook_configs:
+    - url: '`+chat.srv.URL+`'
+      body:
+        text: '{{ .Group }}: {{ range .Events }}{{ .trigger }}={{ .value }} {{ end }}'
+`)
+    var ids []string
+    for _, body := range []string{
+        `{"host": "a", "trigger": "t1", "value": "1"}`,
+        `{"host": "a", "trigger": "t2", "value": "1"}`,
+        `{"host": "b", "trigger": "t1", "value": "1"}`,
+        // Updates the first event, its first item is acknowledged
+        `{"host": "a", "trigger": "t1", "value": "2"}`,
+    } {
+        w := post("/z", body)
+        if w.Code != 202 {
+            t.Fatalf("%s: code %d", body, w.Code)
+        }
+        var resp struct{ ID string }
+        json.Unmarshal(w.Body.Bytes(), &resp)
+        ids = append(ids, resp.ID)
+    }
+    for _, id := range ids {
+        waitFor(t, "grouped "+id, func() bool {
+            return state(id, "/z", "group") == tracker.Grouped
+        })
+    }
+    // Events wait in the queue until their group is notified
+    if n := deliveries.Len(); n != 3 {
+        t.Errorf("%d queued events before group_wait, want 3", n)
+    }
+
+    waitFor(t, "notifications", func() bool { return len(chat.requests()) == 2 })
+    waitFor(t, "acknowledged events", func() bool { return deliveries.Len() == 0 })
+
+    var texts []string
+    for _, body := range chat.requests() {
+        var msg struct{ Text string }
+        if err := json.Unmarshal([]byte(body), &msg); err != nil {
+            t.Fatal(err)
+        }
+        texts = append(texts, strings.TrimSpace(msg.Text))
+    }
+    sort.Strings(texts)
+    if want := []string{"a: t1=2 t2=1", "b: t1=1"}; strings.Join(texts, "|") != strings.Join(want, "|") {
+        t.Errorf("notifications %q, want %q", texts, want)
+    }
+}
+
+func TestGroupSplitBy(t *testing.T) {
+    tests := []struct {
+        name    string
+        conf    string
+    }{
+        {"receiver", `
+receivers:
+- path: '/z'
+  split_by: '$.alerts[*]'
+  group: {}
+  webhook_configs:
+    - url: 'http://localhost'
+`},
+        {"output", `
+receivers:
+- path: '/z'
+  group: {}
+  webhook_configs:
+    - url: 'http://localhost'
+      split_by: '$.alerts[*]'
+`},
+    }
+    for _, tt := range tests {
+        c := &Config{}
+        if err := yaml.UnmarshalStrict([]byte(tt.conf), c); err != nil {
+            t.Fatal(err)
+        }
+        if err := c.validate(); err == nil || !strings.Contains(err.Error(), "group and split_by") {
+            t.Errorf("%s: %v", tt.name, err)
+        }
+    }
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+    "log"
+    "time"
+    "github.com/ltkh/adapter/internal/alertstate"
+    "github.com/ltkh/adapter/internal/inhibit"
+)
+
+var (
+    inhibitor   *inhibit.Inhibitor
+)
+
+// inhibitSource records the alert of a stateful receiver as a source of the
+// inhibition rules, tc renders the alert and the rules match data. Undo
+// restores the previous sources, it is nil when nothing was recorded.
+func (receiver *Receiver) inhibitSource(tc, data interface{}, now time.Time) (undo func()) {
+    fingerprint, state, err := receiver.alert(tc)
+    if err != nil {
+        log.Printf("[error] %v - %s", err, receiver.Path)
+        return nil
+    }
+    return inhibitor.Observe(receiver.Path, fingerprint, state == alertstate.Firing, data, now)
+}
+
+// inhibited returns the firing alert muting the event, if any.
+func (receiver *Receiver) inhibited(tc, data interface{}, now time.Time) (*inhibit.Source, bool) {
+    fingerprint := ""
+    if receiver.State != nil {
+        fingerprint, _, _ = receiver.alert(tc)
+    }
+    return inhibitor.Inhibited(receiver.Path, fingerprint, data, now)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestInhibitRollback(t *testing.T) {
+    a := newTestEndpoint(t, 200)
+    b := newTestEndpoint(t, 200)
+    setup(t, `
+global:
+  listen_address: ':0'
+  queue:
+    max_items: 1
+    queue_depth: 10
+inhibit_rules:
+- source_matchers: ['alertname="Down"']
+  target_matchers: ['alertname!="Down"']
+receivers:
+- path: '/a'
+  state:
+    fingerprint: '{{ .alertname }}'
+  webhook_configs:
+    - url: '`+a.srv.URL+`'
+    - url: '`+b.srv.URL+`'
+`)
+    // Two deliveries do not fit into the queue
+    if w := post("/a", `{"alertname": "Down", "status": "firing"}`); w.Code != 503 {
+        t.Fatalf("code %d, want 503", w.Code)
+    }
+    if list := inhibitor.List(time.Now()); len(list) != 0 {
+        t.Errorf("source of a rejected alert %+v", list)
+    }
+    if _, inhibited := inhibitor.Inhibited("/a", "Slow", map[string]interface{}{"alertname": "Slow"}, time.Now()); inhibited {
+        t.Error("inhibited by a rejected alert")
+    }
+}
@@ -0,0 +1,192 @@
+package alertstate
+
+import (
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Alert states.
+const (
+    Firing   = "firing"
+    Resolved = "resolved"
+)
+
+// Decisions of Observe.
+const (
+    FirstSeen       = "first seen"
+    Changed         = "changed"
+    Reminder        = "reminder"
+    Unchanged       = "unchanged"
+    UnknownResolved = "unknown resolved"
+)
+
+// Alert is the remembered state of one alert.
+type Alert struct {
+    Receiver        string             `json:"receiver"`
+    Fingerprint     string             `json:"fingerprint"`
+    State           string             `json:"state"`
+    // Time of the last transition
+    Since           time.Time          `json:"since"`
+    LastSeen        time.Time          `json:"last_seen"`
+    LastNotified    time.Time          `json:"last_notified"`
+}
+
+// Policy controls which observations are forwarded.
+type Policy struct {
+    // Forward unchanged firing alerts again after this time, never when zero
+    ReminderInterval time.Duration
+    // Do not forward resolved alerts that are not known as firing
+    SuppressUnknownResolved bool
+    // Forget alerts not observed for this long, never when zero
+    TTL time.Duration
+}
+
+// Store keeps the state of the alerts of one receiver in memory.
+type Store struct {
+    mu              sync.Mutex
+    alerts          map[string]*Alert
+    swept           time.Time
+}
+
+func New() *Store {
+    return &Store{alerts: map[string]*Alert{}, swept: time.Now()}
+}
+
+// Normalize maps a rendered status to Firing or Resolved.
+func Normalize(status string) string {
+    switch strings.ToLower(strings.TrimSpace(status)) {
+        case "resolved", "ok", "inactive", "normal":
+            return Resolved
+    }
+    return Firing
+}
+
+// Observe records an alert and reports whether it must be forwarded, with
+// the reason of the decision and the state before the observation, which
+// is nil for unknown alerts.
+func (s *Store) Observe(receiver, fingerprint, state string, now time.Time, p Policy) (bool, string, *Alert) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.sweep(now, p.TTL)
+
+    a, ok := s.alerts[fingerprint]
+    var prev *Alert
+    if ok {
+        copied := *a
+        prev = &copied
+    }
+    if !ok || p.TTL > 0 && now.Sub(a.LastSeen) > p.TTL {
+        if state == Resolved && p.SuppressUnknownResolved {
+            return false, UnknownResolved, prev
+        }
+        s.alerts[fingerprint] = &Alert{
+            Receiver:     receiver,
+            Fingerprint:  fingerprint,
+            State:        state,
+            Since:        now,
+            LastSeen:     now,
+            LastNotified: now,
+        }
+        return true, FirstSeen, prev
+    }
+
+    a.LastSeen = now
+    if a.State != state {
+        a.State = state
+        a.Since = now
+        a.LastNotified = now
+        return true, Changed, prev
+    }
+    // The state was recorded while the alert was muted
+    if a.LastNotified.Before(a.Since) {
+        if state == Resolved && a.LastNotified.IsZero() && p.SuppressUnknownResolved {
+            return false, UnknownResolved, prev
+        }
+        a.LastNotified = now
+        return true, Changed, prev
+    }
+    if state == Firing && p.ReminderInterval > 0 && now.Sub(a.LastNotified) >= p.ReminderInterval {
+        a.LastNotified = now
+        return true, Reminder, prev
+    }
+    return false, Unchanged, prev
+}
+
+// Record updates the state of an alert that is not notified, e.g. because
+// it was silenced, and returns the state before like Observe. A later
+// observation forwards the alert when its state was never notified.
+func (s *Store) Record(receiver, fingerprint, state string, now time.Time, p Policy) *Alert {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.sweep(now, p.TTL)
+
+    a, ok := s.alerts[fingerprint]
+    var prev *Alert
+    if ok {
+        copied := *a
+        prev = &copied
+    }
+    if !ok || p.TTL > 0 && now.Sub(a.LastSeen) > p.TTL {
+        s.alerts[fingerprint] = &Alert{
+            Receiver:    receiver,
+            Fingerprint: fingerprint,
+            State:       state,
+            Since:       now,
+            LastSeen:    now,
+        }
+        return prev
+    }
+
+    a.LastSeen = now
+    if a.State != state {
+        a.State = state
+        a.Since = now
+    }
+    return prev
+}
+
+// Restore puts back the state returned by Observe, used when the
+// notification could not be accepted. A nil state removes the alert.
+func (s *Store) Restore(fingerprint string, prev *Alert) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if prev == nil {
+        delete(s.alerts, fingerprint)
+        return
+    }
+    a := *prev
+    s.alerts[fingerprint] = &a
+}
+
+// List returns the known alerts ordered by fingerprint.
+func (s *Store) List() []Alert {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    list := make([]Alert, 0, len(s.alerts))
+    for _, a := range s.alerts {
+        list = append(list, *a)
+    }
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].Fingerprint < list[j].Fingerprint
+    })
+    return list
+}
+
+// sweep drops expired alerts once a minute, the caller holds the lock.
+func (s *Store) sweep(now time.Time, ttl time.Duration) {
+    if ttl <= 0 || now.Sub(s.swept) < time.Minute {
+        return
+    }
+    for fingerprint, a := range s.alerts {
+        if now.Sub(a.LastSeen) > ttl {
+            delete(s.alerts, fingerprint)
+        }
+    }
+    s.swept = now
+}
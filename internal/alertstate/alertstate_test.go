@@ -0,0 +1,168 @@
+package alertstate
+
+import (
+    "testing"
+    "time"
+)
+
+func TestNormalize(t *testing.T) {
+    tests := []struct {
+        status  string
+        want    string
+    }{
+        {"firing", Firing},
+        {"resolved", Resolved},
+        {" Resolved ", Resolved},
+        {"OK", Resolved},
+        {"inactive", Resolved},
+        {"normal", Resolved},
+        {"problem", Firing},
+        {"", Firing},
+    }
+    for _, tt := range tests {
+        if got := Normalize(tt.status); got != tt.want {
+            t.Errorf("%q: got %s, want %s", tt.status, got, tt.want)
+        }
+    }
+}
+
+func TestObserve(t *testing.T) {
+    start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+    type step struct {
+        state   string
+        after   time.Duration
+        forward bool
+        reason  string
+    }
+    tests := []struct {
+        name    string
+        policy  Policy
+        steps   []step
+    }{
+        {"first seen and unchanged", Policy{}, []step{
+            {Firing, 0, true, FirstSeen},
+            {Firing, time.Minute, false, Unchanged},
+        }},
+        {"changed", Policy{}, []step{
+            {Firing, 0, true, FirstSeen},
+            {Resolved, time.Minute, true, Changed},
+            {Resolved, 2 * time.Minute, false, Unchanged},
+            {Firing, 3 * time.Minute, true, Changed},
+        }},
+        {"reminder", Policy{ReminderInterval: time.Hour}, []step{
+            {Firing, 0, true, FirstSeen},
+            {Firing, 30 * time.Minute, false, Unchanged},
+            {Firing, time.Hour, true, Reminder},
+            {Firing, 90 * time.Minute, false, Unchanged},
+        }},
+        {"no reminder for resolved", Policy{ReminderInterval: time.Hour}, []step{
+            {Resolved, 0, true, FirstSeen},
+            {Resolved, 2 * time.Hour, false, Unchanged},
+        }},
+        {"unknown resolved", Policy{SuppressUnknownResolved: true}, []step{
+            {Resolved, 0, false, UnknownResolved},
+            {Firing, time.Minute, true, FirstSeen},
+            {Resolved, 2 * time.Minute, true, Changed},
+        }},
+        {"expired", Policy{TTL: time.Hour}, []step{
+            {Firing, 0, true, FirstSeen},
+            {Firing, 2 * time.Hour, true, FirstSeen},
+        }},
+    }
+    for _, tt := range tests {
+        s := New()
+        for i, st := range tt.steps {
+            forward, reason, _ := s.Observe("/r", "fp", st.state, start.Add(st.after), tt.policy)
+            if forward != st.forward || reason != st.reason {
+                t.Errorf("%s: step %d: got %v %q, want %v %q", tt.name, i, forward, reason, st.forward, st.reason)
+            }
+        }
+    }
+}
+
+func TestRestore(t *testing.T) {
+    now := time.Now()
+    tests := []struct {
+        name    string
+        before  []string
+        state   string
+        want    []Alert
+    }{
+        {"unknown alert is removed", nil, Firing, nil},
+        {"changed alert is restored", []string{Firing}, Resolved, []Alert{{Receiver: "/r", Fingerprint: "fp", State: Firing}}},
+        {"unchanged alert is restored", []string{Firing}, Firing, []Alert{{Receiver: "/r", Fingerprint: "fp", State: Firing}}},
+    }
+    for _, tt := range tests {
+        s := New()
+        for _, state := range tt.before {
+            s.Observe("/r", "fp", state, now, Policy{})
+        }
+        _, _, prev := s.Observe("/r", "fp", tt.state, now.Add(time.Minute), Policy{})
+        s.Restore("fp", prev)
+
+        got := s.List()
+        if len(got) != len(tt.want) {
+            t.Fatalf("%s: %d alerts, want %d", tt.name, len(got), len(tt.want))
+        }
+        for i, a := range got {
+            if a.State != tt.want[i].State || !a.LastSeen.Equal(now) {
+                t.Errorf("%s: got %+v, want state %s seen at %v", tt.name, a, tt.want[i].State, now)
+            }
+        }
+    }
+}
+
+func TestRecord(t *testing.T) {
+    start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+    type step struct {
+        state   string
+        muted   bool
+        forward bool
+    }
+    tests := []struct {
+        name    string
+        policy  Policy
+        steps   []step
+    }{
+        {"muted first alert is sent later", Policy{}, []step{
+            {Firing, true, false},
+            {Firing, false, true},
+            {Firing, false, false},
+        }},
+        {"muted change is sent later", Policy{}, []step{
+            {Firing, false, true},
+            {Resolved, true, false},
+            {Resolved, false, true},
+        }},
+        {"muted repeat is not sent again", Policy{}, []step{
+            {Firing, false, true},
+            {Firing, true, false},
+            {Firing, false, false},
+        }},
+        {"resolved while muted", Policy{}, []step{
+            {Firing, false, true},
+            {Resolved, true, false},
+            {Firing, false, true},
+        }},
+        {"never notified resolved", Policy{SuppressUnknownResolved: true}, []step{
+            {Resolved, true, false},
+            {Resolved, false, false},
+        }},
+    }
+    for _, tt := range tests {
+        s := New()
+        for i, st := range tt.steps {
+            now := start.Add(time.Duration(i) * time.Minute)
+            if st.muted {
+                s.Record("/r", "fp", st.state, now, tt.policy)
+                continue
+            }
+            if forward, reason, _ := s.Observe("/r", "fp", st.state, now, tt.policy); forward != st.forward {
+                t.Errorf("%s: step %d: forward %v (%s), want %v", tt.name, i, forward, reason, st.forward)
+            }
+        }
+        if got := s.List(); len(got) != 1 || got[0].State != tt.steps[len(tt.steps)-1].state {
+            t.Errorf("%s: state %+v", tt.name, got)
+        }
+    }
+}
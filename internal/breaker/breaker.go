@@ -0,0 +1,222 @@
+package breaker
+
+import (
+    "fmt"
+    "log"
+    "sort"
+    "sync"
+    "time"
+)
+
+type State int
+
+const (
+    Closed State = iota
+    Open
+    HalfOpen
+)
+
+func (s State) String() string {
+    switch s {
+        case Open:
+            return "open"
+        case HalfOpen:
+            return "half-open"
+    }
+    return "closed"
+}
+
+func (s State) MarshalText() ([]byte, error) {
+    return []byte(s.String()), nil
+}
+
+type Config struct {
+    // Consecutive failures after which the circuit opens, disabled when zero
+    FailureThreshold int
+    // Time the circuit stays open before probing
+    OpenPeriod time.Duration
+    // Successful probes in half-open state needed to close the circuit
+    HalfOpenProbes int
+}
+
+// OpenError is returned while the circuit of a destination is open.
+type OpenError struct {
+    Destination string
+    RetryAfter  time.Duration
+}
+
+func (e *OpenError) Error() string {
+    return fmt.Sprintf("circuit breaker for %s is open", e.Destination)
+}
+
+// Breaker tracks the health of a single destination.
+type Breaker struct {
+    mu          sync.Mutex
+    destination string
+    config      Config
+    state       State
+    failures    int
+    successes   int
+    probing     bool
+    openedAt    time.Time
+    lastError   string
+}
+
+// Status is a snapshot of a breaker for the admin endpoint.
+type Status struct {
+    Destination string     `json:"destination"`
+    State       State      `json:"state"`
+    Failures    int        `json:"failures"`
+    OpenedAt    *time.Time `json:"opened_at,omitempty"`
+    LastError   string     `json:"last_error,omitempty"`
+}
+
+// Allow returns an OpenError when the call must not be made. In half-open
+// state a single probe is let through at a time.
+func (b *Breaker) Allow() error {
+    if b == nil {
+        return nil
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    switch b.state {
+        case Open:
+            wait := b.config.OpenPeriod - time.Since(b.openedAt)
+            if wait > 0 {
+                return &OpenError{Destination: b.destination, RetryAfter: wait}
+            }
+            b.setState(HalfOpen)
+            b.successes = 0
+            b.probing = true
+        case HalfOpen:
+            if b.probing {
+                return &OpenError{Destination: b.destination, RetryAfter: b.config.OpenPeriod}
+            }
+            b.probing = true
+    }
+    return nil
+}
+
+// Report records the outcome of an allowed call.
+func (b *Breaker) Report(err error) {
+    if b == nil {
+        return
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.probing = false
+    if err == nil {
+        b.failures = 0
+        if b.state == HalfOpen {
+            b.successes++
+            if b.successes >= b.config.HalfOpenProbes {
+                b.setState(Closed)
+            }
+        }
+        return
+    }
+
+    b.lastError = err.Error()
+    b.failures++
+    if b.state == HalfOpen || b.failures >= b.config.FailureThreshold {
+        b.openedAt = time.Now()
+        b.setState(Open)
+    }
+}
+
+// Release ends an allowed call without recording its outcome, a half-open
+// breaker lets the next probe through.
+func (b *Breaker) Release() {
+    if b == nil {
+        return
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.probing = false
+}
+
+func (b *Breaker) setState(state State) {
+    if b.state == state {
+        return
+    }
+    b.state = state
+    if state == Open {
+        log.Printf("[warn] circuit breaker %s: %s for %v after %d failures (%s)", b.destination, state, b.config.OpenPeriod, b.failures, b.lastError)
+    } else {
+        log.Printf("[info] circuit breaker %s: %s", b.destination, state)
+    }
+}
+
+func (b *Breaker) status() Status {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    s := Status{Destination: b.destination, State: b.state, Failures: b.failures, LastError: b.lastError}
+    if b.state != Closed {
+        openedAt := b.openedAt
+        s.OpenedAt = &openedAt
+    }
+    return s
+}
+
+// Registry holds one breaker per destination.
+type Registry struct {
+    mu       sync.Mutex
+    config   Config
+    breakers map[string]*Breaker
+}
+
+func NewRegistry(c Config) *Registry {
+    if c.OpenPeriod <= 0 {
+        c.OpenPeriod = 30 * time.Second
+    }
+    if c.HalfOpenProbes <= 0 {
+        c.HalfOpenProbes = 1
+    }
+    return &Registry{config: c, breakers: map[string]*Breaker{}}
+}
+
+// Get returns the breaker of destination, nil when breakers are disabled.
+func (r *Registry) Get(destination string) *Breaker {
+    if r == nil || r.config.FailureThreshold <= 0 {
+        return nil
+    }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    b, ok := r.breakers[destination]
+    if !ok {
+        b = &Breaker{destination: destination, config: r.config}
+        r.breakers[destination] = b
+    }
+    return b
+}
+
+// Status returns the state of all known destinations.
+func (r *Registry) Status() []Status {
+    if r == nil {
+        return []Status{}
+    }
+
+    r.mu.Lock()
+    list := make([]*Breaker, 0, len(r.breakers))
+    for _, b := range r.breakers {
+        list = append(list, b)
+    }
+    r.mu.Unlock()
+
+    status := make([]Status, 0, len(list))
+    for _, b := range list {
+        status = append(status, b.status())
+    }
+    sort.Slice(status, func(i, j int) bool {
+        return status[i].Destination < status[j].Destination
+    })
+    return status
+}
@@ -0,0 +1,94 @@
+package breaker
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestBreaker(t *testing.T) {
+    failed := errors.New("failed")
+    type step struct {
+        // allow, ok, fail, release or wait
+        op    string
+        want  State
+        open  bool
+    }
+    tests := []struct {
+        name  string
+        steps []step
+    }{
+        {"opens after threshold", []step{
+            {"fail", Closed, false},
+            {"fail", Open, false},
+            {"allow", Open, true},
+        }},
+        {"success resets failures", []step{
+            {"fail", Closed, false},
+            {"ok", Closed, false},
+            {"fail", Closed, false},
+        }},
+        {"probe closes", []step{
+            {"fail", Closed, false},
+            {"fail", Open, false},
+            {"wait", Open, false},
+            {"allow", HalfOpen, false},
+            {"allow", HalfOpen, true},
+            {"ok", Closed, false},
+        }},
+        {"failed probe opens again", []step{
+            {"fail", Closed, false},
+            {"fail", Open, false},
+            {"wait", Open, false},
+            {"allow", HalfOpen, false},
+            {"fail", Open, false},
+        }},
+        {"released probe stays half-open", []step{
+            {"fail", Closed, false},
+            {"fail", Open, false},
+            {"wait", Open, false},
+            {"allow", HalfOpen, false},
+            {"release", HalfOpen, false},
+            {"allow", HalfOpen, false},
+        }},
+    }
+    for _, tt := range tests {
+        r := NewRegistry(Config{FailureThreshold: 2, OpenPeriod: 20 * time.Millisecond})
+        b := r.Get("http://a")
+        for i, s := range tt.steps {
+            var err error
+            switch s.op {
+                case "allow":
+                    err = b.Allow()
+                case "ok":
+                    b.Allow()
+                    b.Report(nil)
+                case "fail":
+                    b.Allow()
+                    b.Report(failed)
+                case "release":
+                    b.Release()
+                case "wait":
+                    time.Sleep(30 * time.Millisecond)
+            }
+            var openErr *OpenError
+            if errors.As(err, &openErr) != s.open {
+                t.Errorf("%s: step %d %s: error %v", tt.name, i, s.op, err)
+            }
+            if b.state != s.want {
+                t.Errorf("%s: step %d %s: state %v, want %v", tt.name, i, s.op, b.state, s.want)
+            }
+        }
+    }
+}
+
+func TestDisabled(t *testing.T) {
+    var r *Registry
+    if b := r.Get("http://a"); b != nil || b.Allow() != nil {
+        t.Errorf("nil registry returned a breaker")
+    }
+    r = NewRegistry(Config{})
+    if b := r.Get("http://a"); b != nil {
+        t.Errorf("breaker without threshold")
+    }
+}
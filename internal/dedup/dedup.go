@@ -0,0 +1,55 @@
+package dedup
+
+import (
+    "sync"
+    "time"
+)
+
+// Cache remembers keys for a time window together with the id of the
+// request that first used them.
+type Cache struct {
+    mu              sync.Mutex
+    entries         map[string]entry
+    swept           time.Time
+}
+
+type entry struct {
+    id              string
+    expires         time.Time
+}
+
+func New() *Cache {
+    return &Cache{entries: map[string]entry{}, swept: time.Now()}
+}
+
+// Add remembers key for window. When key is already known it returns the
+// id it was first added with and true, the window is not extended.
+func (c *Cache) Add(key, id string, window time.Duration) (string, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    now := time.Now()
+    if e, ok := c.entries[key]; ok && now.Before(e.expires) {
+        return e.id, true
+    }
+    c.entries[key] = entry{id: id, expires: now.Add(window)}
+
+    // Drop expired keys once a minute
+    if now.Sub(c.swept) > time.Minute {
+        for k, e := range c.entries {
+            if !now.Before(e.expires) {
+                delete(c.entries, k)
+            }
+        }
+        c.swept = now
+    }
+    return "", false
+}
+
+// Remove forgets key, used when the request could not be accepted.
+func (c *Cache) Remove(key string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    delete(c.entries, key)
+}
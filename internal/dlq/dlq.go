@@ -0,0 +1,152 @@
+package dlq
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Entry is a delivery that finally failed.
+type Entry struct {
+    ID              string             `json:"id"`
+    Receiver        string             `json:"receiver"`
+    Output          string             `json:"output"`
+    // Original payload as received
+    Payload         json.RawMessage    `json:"payload"`
+    // Metadata of the original request
+    Request         json.RawMessage    `json:"request,omitempty"`
+    // Elements of a split payload that were already handled
+    Done            []int              `json:"done,omitempty"`
+    // Results of the pipeline steps that were already sent
+    Steps           map[string]interface{} `json:"steps,omitempty"`
+    // Last rendered body, empty when rendering failed
+    Rendered        string             `json:"rendered,omitempty"`
+    Attempts        []Attempt          `json:"attempts"`
+    ReceivedAt      time.Time          `json:"received_at"`
+    FailedAt        time.Time          `json:"failed_at"`
+}
+
+type Attempt struct {
+    Time            time.Time          `json:"time"`
+    Error           string             `json:"error"`
+}
+
+// LastError returns the error of the last attempt.
+func (e *Entry) LastError() string {
+    if len(e.Attempts) == 0 {
+        return ""
+    }
+    return e.Attempts[len(e.Attempts)-1].Error
+}
+
+// validID matches the ids assigned by Add, ids are used as file names.
+var validID = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}-[0-9a-f]{8}$`)
+
+func checkID(id string) error {
+    if !validID.MatchString(id) {
+        return fmt.Errorf("invalid id %q", id)
+    }
+    return nil
+}
+
+// Store keeps one json file per entry in a directory.
+type Store struct {
+    dir string
+}
+
+func Open(dir string) (*Store, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, err
+    }
+    return &Store{dir: dir}, nil
+}
+
+// Add assigns an id to the entry unless it has one and writes it to disk.
+func (s *Store) Add(e *Entry) error {
+    if e.ID == "" {
+        suffix := make([]byte, 4)
+        if _, err := rand.Read(suffix); err != nil {
+            return err
+        }
+        e.ID = fmt.Sprintf("%s-%s", e.FailedAt.UTC().Format("20060102T150405"), hex.EncodeToString(suffix))
+    }
+    if err := checkID(e.ID); err != nil {
+        return err
+    }
+
+    data, err := json.MarshalIndent(e, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    // Write and rename so that readers never see partial entries
+    tmp := filepath.Join(s.dir, "."+e.ID+".tmp")
+    if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, s.path(e.ID))
+}
+
+func (s *Store) Get(id string) (*Entry, error) {
+    if err := checkID(id); err != nil {
+        return nil, err
+    }
+    data, err := ioutil.ReadFile(s.path(id))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, fmt.Errorf("entry %s not found", id)
+        }
+        return nil, err
+    }
+    e := &Entry{}
+    if err := json.Unmarshal(data, e); err != nil {
+        return nil, fmt.Errorf("entry %s: %v", id, err)
+    }
+    return e, nil
+}
+
+// List returns all entries ordered by failure time.
+func (s *Store) List() ([]*Entry, error) {
+    files, err := ioutil.ReadDir(s.dir)
+    if err != nil {
+        return nil, err
+    }
+
+    var entries []*Entry
+    for _, f := range files {
+        name := f.Name()
+        id := strings.TrimSuffix(name, ".json")
+        if !strings.HasSuffix(name, ".json") || checkID(id) != nil {
+            continue
+        }
+        e, err := s.Get(id)
+        if err != nil {
+            return nil, err
+        }
+        entries = append(entries, e)
+    }
+
+    sort.Slice(entries, func(i, j int) bool {
+        return entries[i].FailedAt.Before(entries[j].FailedAt)
+    })
+    return entries, nil
+}
+
+func (s *Store) Remove(id string) error {
+    if err := checkID(id); err != nil {
+        return err
+    }
+    return os.Remove(s.path(id))
+}
+
+func (s *Store) path(id string) string {
+    return filepath.Join(s.dir, id+".json")
+}
@@ -0,0 +1,68 @@
+package dlq
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestIDs(t *testing.T) {
+    dir, err := ioutil.TempDir("", "dlq")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    store, err := Open(filepath.Join(dir, "dlq"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    outside := filepath.Join(dir, "x.json")
+    if err := ioutil.WriteFile(outside, []byte("{}"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    e := &Entry{Receiver: "/a", Output: "webhook_configs[0]", Payload: []byte("{}"), FailedAt: time.Now()}
+    if err := store.Add(e); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        id    string
+        valid bool
+    }{
+        {e.ID, true},
+        {"../x", false},
+        {"..", false},
+        {"20240101T000000-0000000g", false},
+        {"20240101T000000-00000000/../../x", false},
+        {"", false},
+    }
+    for _, tt := range tests {
+        _, err := store.Get(tt.id)
+        if (err == nil) != tt.valid {
+            t.Errorf("get %q: %v", tt.id, err)
+        }
+        if !tt.valid {
+            if err := store.Remove(tt.id); err == nil {
+                t.Errorf("remove %q accepted", tt.id)
+            }
+        }
+    }
+    if _, err := os.Stat(outside); err != nil {
+        t.Errorf("file outside the store removed: %v", err)
+    }
+
+    if err := store.Add(&Entry{ID: "../y", FailedAt: time.Now()}); err == nil {
+        t.Errorf("add with an invalid id accepted")
+    }
+    entries, err := store.List()
+    if err != nil || len(entries) != 1 {
+        t.Errorf("list: %d entries, %v", len(entries), err)
+    }
+    if err := store.Remove(e.ID); err != nil {
+        t.Errorf("remove: %v", err)
+    }
+}
@@ -0,0 +1,247 @@
+package inhibit
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/ltkh/adapter/internal/jsonpath"
+    "github.com/ltkh/adapter/internal/route"
+)
+
+// Rule mutes the alerts matching the target matchers while an alert
+// matching the source matchers is firing with the same equal fields.
+type Rule struct {
+    // Matchers on payload fields, see routes
+    SourceMatchers  []string           `yaml:"source_matchers" json:"source_matchers"`
+    TargetMatchers  []string           `yaml:"target_matchers" json:"target_matchers"`
+    // Fields that must have the same value in source and target, e.g. labels.site
+    Equal           []string           `yaml:"equal,omitempty" json:"equal,omitempty"`
+    // Sources stop inhibiting when they are not received again for this
+    // long, 24h by default.
+    SourceTTL       string             `yaml:"source_ttl,omitempty" json:"source_ttl,omitempty"`
+
+    ttl             time.Duration
+    source          []*route.Matcher
+    target          []*route.Matcher
+    equal           []*jsonpath.Path
+}
+
+// Compile parses the matchers, the equal fields and the expiry of the rule.
+func (r *Rule) Compile() error {
+    if len(r.SourceMatchers) == 0 {
+        return fmt.Errorf("source_matchers are required")
+    }
+    if len(r.TargetMatchers) == 0 {
+        return fmt.Errorf("target_matchers are required")
+    }
+
+    if r.SourceTTL == "" {
+        r.SourceTTL = "24h"
+    }
+    var err error
+    if r.ttl, err = time.ParseDuration(r.SourceTTL); err != nil {
+        return fmt.Errorf("source_ttl: %v", err)
+    }
+    if r.source, err = parseMatchers(r.SourceMatchers); err != nil {
+        return err
+    }
+    if r.target, err = parseMatchers(r.TargetMatchers); err != nil {
+        return err
+    }
+    r.equal = nil
+    for _, name := range r.Equal {
+        expr := name
+        if !strings.HasPrefix(expr, "$") {
+            expr = "$." + expr
+        }
+        path, err := jsonpath.Compile(expr)
+        if err != nil {
+            return fmt.Errorf("equal %q: %v", name, err)
+        }
+        r.equal = append(r.equal, path)
+    }
+    return nil
+}
+
+func parseMatchers(list []string) ([]*route.Matcher, error) {
+    var matchers []*route.Matcher
+    for _, expr := range list {
+        m, err := route.ParseMatcher(expr)
+        if err != nil {
+            return nil, err
+        }
+        matchers = append(matchers, m)
+    }
+    return matchers, nil
+}
+
+func matches(matchers []*route.Matcher, doc interface{}) bool {
+    for _, m := range matchers {
+        if !m.Matches(doc) {
+            return false
+        }
+    }
+    return true
+}
+
+// values returns the equal fields of doc as one comparable string.
+func (r *Rule) values(doc interface{}) string {
+    var list []string
+    for _, path := range r.equal {
+        var field []string
+        for _, v := range path.Lookup(doc) {
+            switch v := v.(type) {
+                case nil:
+                case string:
+                    field = append(field, v)
+                default:
+                    data, _ := json.Marshal(v)
+                    field = append(field, string(data))
+            }
+        }
+        list = append(list, strings.Join(field, ","))
+    }
+    data, _ := json.Marshal(list)
+    return string(data)
+}
+
+// Source is a firing alert that inhibits others.
+type Source struct {
+    // Index of the rule in inhibit_rules
+    Rule            int                `json:"rule"`
+    Receiver        string             `json:"receiver"`
+    Fingerprint     string             `json:"fingerprint"`
+    Since           time.Time          `json:"since"`
+    LastSeen        time.Time          `json:"last_seen"`
+
+    values          string
+    expires         time.Time
+}
+
+// Inhibitor keeps the firing source alerts of the rules in memory.
+type Inhibitor struct {
+    mu              sync.Mutex
+    rules           []*Rule
+    sources         map[string]*Source
+}
+
+// New returns an inhibitor for compiled rules.
+func New(rules []*Rule) *Inhibitor {
+    return &Inhibitor{rules: rules, sources: map[string]*Source{}}
+}
+
+// Enabled reports whether there are rules, callers skip rendering the
+// alerts otherwise.
+func (in *Inhibitor) Enabled() bool {
+    return in != nil && len(in.rules) > 0
+}
+
+// Observe records a firing alert as a source of the rules it matches and
+// forgets it once resolved, whether the resolved alert still matches or
+// not. Sources not observed again within the source_ttl of their rule
+// expire. Undo restores the previous sources when the alert is not
+// accepted after all.
+func (in *Inhibitor) Observe(receiver, fingerprint string, firing bool, doc interface{}, now time.Time) (undo func()) {
+    if !in.Enabled() {
+        return func() {}
+    }
+
+    in.mu.Lock()
+    defer in.mu.Unlock()
+
+    prev := map[string]*Source{}
+    for i, r := range in.rules {
+        if firing && !matches(r.source, doc) {
+            continue
+        }
+        key := fmt.Sprintf("%d/%s/%s", i, receiver, fingerprint)
+        s, ok := in.sources[key]
+        prev[key] = nil
+        if ok {
+            saved := *s
+            prev[key] = &saved
+        }
+        if !firing {
+            delete(in.sources, key)
+            continue
+        }
+        if !ok || now.After(s.expires) {
+            s = &Source{Rule: i, Receiver: receiver, Fingerprint: fingerprint, Since: now}
+            in.sources[key] = s
+        }
+        s.LastSeen = now
+        s.values = r.values(doc)
+        s.expires = now.Add(r.ttl)
+    }
+
+    return func() {
+        in.mu.Lock()
+        defer in.mu.Unlock()
+        for key, s := range prev {
+            if s == nil {
+                delete(in.sources, key)
+            } else {
+                in.sources[key] = s
+            }
+        }
+    }
+}
+
+// Inhibited returns the source muting doc, if any. An alert never inhibits
+// itself, fingerprint identifies it among the sources.
+func (in *Inhibitor) Inhibited(receiver, fingerprint string, doc interface{}, now time.Time) (*Source, bool) {
+    if !in.Enabled() {
+        return nil, false
+    }
+
+    in.mu.Lock()
+    defer in.mu.Unlock()
+
+    for key, s := range in.sources {
+        if now.After(s.expires) {
+            delete(in.sources, key)
+        }
+    }
+    for i, r := range in.rules {
+        if !matches(r.target, doc) {
+            continue
+        }
+        values := r.values(doc)
+        for _, s := range in.sources {
+            if s.Rule != i || s.values != values {
+                continue
+            }
+            if fingerprint != "" && s.Receiver == receiver && s.Fingerprint == fingerprint {
+                continue
+            }
+            c := *s
+            return &c, true
+        }
+    }
+    return nil, false
+}
+
+// List returns copies of the active sources.
+func (in *Inhibitor) List(now time.Time) []Source {
+    list := []Source{}
+    if in == nil {
+        return list
+    }
+
+    in.mu.Lock()
+    defer in.mu.Unlock()
+
+    for _, s := range in.sources {
+        if !now.After(s.expires) {
+            list = append(list, *s)
+        }
+    }
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].Since.Before(list[j].Since)
+    })
+    return list
+}
@@ -0,0 +1,158 @@
+package inhibit
+
+import (
+    "encoding/json"
+    "testing"
+    "time"
+)
+
+func doc(s string) interface{} {
+    var v interface{}
+    if err := json.Unmarshal([]byte(s), &v); err != nil {
+        panic(err)
+    }
+    return v
+}
+
+func TestCompile(t *testing.T) {
+    tests := []struct {
+        name    string
+        rule    Rule
+        valid   bool
+    }{
+        {"valid", Rule{SourceMatchers: []string{`alertname="Down"`}, TargetMatchers: []string{`severity="warning"`}, Equal: []string{"site"}}, true},
+        {"source ttl", Rule{SourceMatchers: []string{`alertname="Down"`}, TargetMatchers: []string{`severity="warning"`}, SourceTTL: "10m"}, true},
+        {"bad source ttl", Rule{SourceMatchers: []string{`alertname="Down"`}, TargetMatchers: []string{`severity="warning"`}, SourceTTL: "soon"}, false},
+        {"no source", Rule{TargetMatchers: []string{`severity="warning"`}}, false},
+        {"no target", Rule{SourceMatchers: []string{`alertname="Down"`}}, false},
+        {"bad matcher", Rule{SourceMatchers: []string{`alertname=~"("`}, TargetMatchers: []string{`severity="warning"`}}, false},
+        {"bad equal", Rule{SourceMatchers: []string{`alertname="Down"`}, TargetMatchers: []string{`severity="warning"`}, Equal: []string{"labels["}}, false},
+    }
+    for _, tt := range tests {
+        rule := tt.rule
+        if err := rule.Compile(); (err == nil) != tt.valid {
+            t.Errorf("%s: %v", tt.name, err)
+        }
+    }
+}
+
+func TestInhibited(t *testing.T) {
+    source := doc(`{"alertname": "Down", "site": "msk1"}`)
+    start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+    tests := []struct {
+        name        string
+        firing      bool
+        target      interface{}
+        receiver    string
+        fingerprint string
+        after       time.Duration
+        want        bool
+    }{
+        {"same site", true, doc(`{"alertname": "Slow", "site": "msk1"}`), "/b", "slow", 0, true},
+        {"other site", true, doc(`{"alertname": "Slow", "site": "spb1"}`), "/b", "slow", 0, false},
+        {"no target match", true, doc(`{"alertname": "Down", "site": "msk1"}`), "/b", "down2", 0, false},
+        {"source resolved", false, doc(`{"alertname": "Slow", "site": "msk1"}`), "/b", "slow", 0, false},
+        {"source expired", true, doc(`{"alertname": "Slow", "site": "msk1"}`), "/b", "slow", 2 * time.Hour, false},
+        {"within source ttl", true, doc(`{"alertname": "Slow", "site": "msk1"}`), "/b", "slow", 30 * time.Minute, true},
+        {"never itself", true, source, "/a", "down", 0, false},
+    }
+    for _, tt := range tests {
+        rule := &Rule{
+            SourceMatchers: []string{`alertname="Down"`},
+            TargetMatchers: []string{`alertname!="Down"`, `alertname!=""`},
+            Equal:          []string{"site"},
+            SourceTTL:      "1h",
+        }
+        if tt.name == "never itself" {
+            rule.TargetMatchers = []string{`site="msk1"`}
+        }
+        if err := rule.Compile(); err != nil {
+            t.Fatal(err)
+        }
+        in := New([]*Rule{rule})
+        in.Observe("/a", "down", true, source, start)
+        if !tt.firing {
+            in.Observe("/a", "down", false, source, start)
+        }
+
+        src, ok := in.Inhibited(tt.receiver, tt.fingerprint, tt.target, start.Add(tt.after))
+        if ok != tt.want {
+            t.Errorf("%s: inhibited %v, want %v", tt.name, ok, tt.want)
+        }
+        if ok && (src.Receiver != "/a" || src.Fingerprint != "down") {
+            t.Errorf("%s: source %+v", tt.name, src)
+        }
+    }
+}
+
+func TestEnabled(t *testing.T) {
+    var none *Inhibitor
+    tests := []struct {
+        name    string
+        in      *Inhibitor
+        want    bool
+    }{
+        {"nil", none, false},
+        {"no rules", New(nil), false},
+        {"rules", New([]*Rule{{}}), true},
+    }
+    for _, tt := range tests {
+        if got := tt.in.Enabled(); got != tt.want {
+            t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestObserve(t *testing.T) {
+    start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+    source := doc(`{"alertname": "Down", "site": "msk1"}`)
+    target := doc(`{"alertname": "Slow", "site": "msk1"}`)
+
+    tests := []struct {
+        name    string
+        firing  bool
+        doc     interface{}
+        undo    bool
+        want    bool
+    }{
+        {"refreshed", true, source, false, true},
+        {"resolved", false, source, false, false},
+        // The resolved payload of an alert may no longer match the source
+        {"resolved without match", false, doc(`{"alertname": "Down"}`), false, false},
+        {"other site", true, doc(`{"alertname": "Down", "site": "spb1"}`), false, false},
+        {"undone resolve", false, source, true, true},
+        {"undone move", true, doc(`{"alertname": "Down", "site": "spb1"}`), true, true},
+    }
+    for _, tt := range tests {
+        rule := &Rule{
+            SourceMatchers: []string{`alertname="Down"`, `site!=""`},
+            TargetMatchers: []string{`alertname="Slow"`},
+            Equal:          []string{"site"},
+        }
+        if err := rule.Compile(); err != nil {
+            t.Fatal(err)
+        }
+        in := New([]*Rule{rule})
+        in.Observe("/a", "down", true, source, start)
+
+        undo := in.Observe("/a", "down", tt.firing, tt.doc, start.Add(time.Minute))
+        if tt.undo {
+            undo()
+        }
+        if _, ok := in.Inhibited("/b", "slow", target, start.Add(time.Minute)); ok != tt.want {
+            t.Errorf("%s: inhibited %v, want %v", tt.name, ok, tt.want)
+        }
+    }
+
+    // A new source is forgotten again by its undo
+    rule := &Rule{SourceMatchers: []string{`alertname="Down"`}, TargetMatchers: []string{`alertname="Slow"`}}
+    if err := rule.Compile(); err != nil {
+        t.Fatal(err)
+    }
+    in := New([]*Rule{rule})
+    in.Observe("/a", "down", true, source, start)()
+    if list := in.List(start); len(list) != 0 {
+        t.Errorf("undone source %+v", list)
+    }
+}
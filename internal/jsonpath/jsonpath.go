@@ -0,0 +1,129 @@
+package jsonpath
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Path is a compiled JSONPath expression. The supported subset is
+// the root ($), child access (.name or ['name']), array indexes ([0], [-1])
+// and wildcards (.* or [*]).
+type Path struct {
+    expr  string
+    steps []step
+}
+
+type step struct {
+    key      string
+    index    int
+    isIndex  bool
+    wildcard bool
+}
+
+func Compile(expr string) (*Path, error) {
+    p := &Path{expr: expr}
+    s := strings.TrimSpace(expr)
+    if !strings.HasPrefix(s, "$") {
+        return nil, fmt.Errorf("jsonpath %q: must start with $", expr)
+    }
+    s = s[1:]
+
+    for len(s) > 0 {
+        switch s[0] {
+            case '.':
+                s = s[1:]
+                end := strings.IndexAny(s, ".[")
+                if end < 0 {
+                    end = len(s)
+                }
+                name := s[:end]
+                if name == "" {
+                    return nil, fmt.Errorf("jsonpath %q: empty name", expr)
+                }
+                if name == "*" {
+                    p.steps = append(p.steps, step{wildcard: true})
+                } else {
+                    p.steps = append(p.steps, step{key: name})
+                }
+                s = s[end:]
+            case '[':
+                end := strings.Index(s, "]")
+                if end < 0 {
+                    return nil, fmt.Errorf("jsonpath %q: missing ]", expr)
+                }
+                sel := strings.TrimSpace(s[1:end])
+                s = s[end+1:]
+                switch {
+                    case sel == "*":
+                        p.steps = append(p.steps, step{wildcard: true})
+                    case len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0]:
+                        p.steps = append(p.steps, step{key: sel[1:len(sel)-1]})
+                    default:
+                        i, err := strconv.Atoi(sel)
+                        if err != nil {
+                            return nil, fmt.Errorf("jsonpath %q: invalid index %q", expr, sel)
+                        }
+                        p.steps = append(p.steps, step{index: i, isIndex: true})
+                }
+            default:
+                return nil, fmt.Errorf("jsonpath %q: unexpected %q", expr, s[0])
+        }
+    }
+    return p, nil
+}
+
+func (p *Path) String() string {
+    return p.expr
+}
+
+// Lookup returns every value in doc selected by the path. Missing keys and
+// out of range indexes select nothing. Wildcards select the members of an
+// object in key order, so the result is the same on every call.
+func (p *Path) Lookup(doc interface{}) []interface{} {
+    current := []interface{}{doc}
+    for _, st := range p.steps {
+        var next []interface{}
+        for _, node := range current {
+            switch n := node.(type) {
+                case map[string]interface{}:
+                    if st.wildcard {
+                        keys := make([]string, 0, len(n))
+                        for k := range n {
+                            keys = append(keys, k)
+                        }
+                        sort.Strings(keys)
+                        for _, k := range keys {
+                            next = append(next, n[k])
+                        }
+                    } else if v, ok := n[st.key]; ok && !st.isIndex {
+                        next = append(next, v)
+                    }
+                case []interface{}:
+                    if st.wildcard {
+                        next = append(next, n...)
+                    } else if st.isIndex {
+                        i := st.index
+                        if i < 0 {
+                            i += len(n)
+                        }
+                        if i >= 0 && i < len(n) {
+                            next = append(next, n[i])
+                        }
+                    }
+            }
+        }
+        current = next
+    }
+    return current
+}
+
+// Lookup compiles expr and applies it to doc.
+func Lookup(expr string, doc interface{}) ([]interface{}, error) {
+    p, err := Compile(expr)
+    if err != nil {
+        return nil, err
+    }
+    return p.Lookup(doc), nil
+}
@@ -0,0 +1,72 @@
+package jsonpath
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+)
+
+func TestCompile(t *testing.T) {
+    tests := []struct {
+        expr    string
+        valid   bool
+    }{
+        {"$", true},
+        {"$.alerts", true},
+        {"$.alerts[*].labels", true},
+        {"$['alerts'][0]", true},
+        {"$[\"alerts\"][-1]", true},
+        {"$.*", true},
+        {"alerts", false},
+        {"$.", false},
+        {"$.alerts..labels", false},
+        {"$.alerts[0", false},
+        {"$.alerts[x]", false},
+        {"$alerts", false},
+    }
+    for _, tt := range tests {
+        if _, err := Compile(tt.expr); (err == nil) != tt.valid {
+            t.Errorf("%s: %v", tt.expr, err)
+        }
+    }
+}
+
+func TestLookup(t *testing.T) {
+    var doc interface{}
+    err := json.Unmarshal([]byte(`{
+        "alerts": [{"name": "a"}, {"name": "b"}, {"name": "c"}],
+        "labels": {"zone": "z", "env": "prod", "app": "web", "team": "db"},
+        "count": 3
+    }`), &doc)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        expr    string
+        want    []interface{}
+    }{
+        {"$.count", []interface{}{3.0}},
+        {"$.alerts[*].name", []interface{}{"a", "b", "c"}},
+        {"$.alerts[1].name", []interface{}{"b"}},
+        {"$.alerts[-1].name", []interface{}{"c"}},
+        {"$['labels']['env']", []interface{}{"prod"}},
+        {"$.alerts[3]", nil},
+        {"$.alerts[-4]", nil},
+        {"$.missing", nil},
+        {"$.count.x", nil},
+        {"$.labels[0]", nil},
+        // Object members are selected in key order
+        {"$.labels.*", []interface{}{"web", "prod", "db", "z"}},
+        {"$.labels[*]", []interface{}{"web", "prod", "db", "z"}},
+    }
+    for _, tt := range tests {
+        got, err := Lookup(tt.expr, doc)
+        if err != nil {
+            t.Fatalf("%s: %v", tt.expr, err)
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: got %v, want %v", tt.expr, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,562 @@
+package queue
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    recordPut byte = 1
+    recordAck byte = 2
+    // Put with the due time (unix nanoseconds) prepended to the data
+    recordPutDelayed byte = 3
+
+    // length + crc + type + id
+    headerSize = 4 + 4 + 1 + 8
+)
+
+var (
+    ErrFull   = errors.New("queue is full")
+    ErrClosed = errors.New("queue is closed")
+)
+
+type Config struct {
+    // Directory of the write-ahead log, the queue is kept in memory only when empty
+    Dir string
+    // Maximum number of pending items
+    MaxItems int
+    // Size in bytes after which a new segment file is started
+    SegmentSize int64
+    // Fsync policy: always, interval or never
+    Fsync string
+    // Period of background fsync for the interval policy
+    FsyncInterval time.Duration
+}
+
+type Item struct {
+    ID   uint64
+    Data []byte
+    // Item is not handed out before this time
+    Due  time.Time
+}
+
+// Queue is a persistent FIFO queue backed by a segmented write-ahead log.
+// Every Put and Ack is appended to the current segment, segments are removed
+// from the head once all items written to them are acknowledged.
+type Queue struct {
+    config   Config
+    mu       sync.Mutex
+    items    chan Item
+    pending  map[uint64]*segment
+    // pending items scheduled for later
+    waiting  map[uint64]bool
+    segments []*segment
+    current  *segment
+    nextID   uint64
+    closed   bool
+    done     chan struct{}
+}
+
+type segment struct {
+    seq     uint64
+    path    string
+    file    *os.File
+    writer  *bufio.Writer
+    size    int64
+    pending int
+}
+
+func ValidFsync(policy string) bool {
+    switch policy {
+        case "", "always", "interval", "never":
+            return true
+    }
+    return false
+}
+
+// Open opens the queue in c.Dir and schedules all pending items found there.
+func Open(c Config) (*Queue, error) {
+    if c.MaxItems <= 0 {
+        c.MaxItems = 10000
+    }
+    if c.SegmentSize <= 0 {
+        c.SegmentSize = 8 << 20
+    }
+    if c.FsyncInterval <= 0 {
+        c.FsyncInterval = time.Second
+    }
+
+    q := &Queue{
+        config:  c,
+        pending: map[uint64]*segment{},
+        waiting: map[uint64]bool{},
+        nextID:  1,
+        done:    make(chan struct{}),
+    }
+
+    var replay []Item
+    if c.Dir != "" {
+        if err := os.MkdirAll(c.Dir, 0755); err != nil {
+            return nil, err
+        }
+        items, err := q.load()
+        if err != nil {
+            return nil, err
+        }
+        replay = items
+        if err := q.rotate(); err != nil {
+            return nil, err
+        }
+        if c.Fsync == "interval" {
+            go q.syncLoop()
+        }
+    }
+
+    size := c.MaxItems
+    if len(replay) > size {
+        size = len(replay)
+    }
+    q.items = make(chan Item, size)
+    q.mu.Lock()
+    for _, item := range replay {
+        q.schedule(item)
+    }
+    q.mu.Unlock()
+
+    return q, nil
+}
+
+// Items returns the channel of items ready for processing.
+// The channel is closed by Close.
+func (q *Queue) Items() <-chan Item {
+    return q.items
+}
+
+// Len returns the number of pending (not acknowledged) items.
+func (q *Queue) Len() int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return len(q.pending)
+}
+
+// Ready returns the number of items waiting to be handed out.
+func (q *Queue) Ready() int {
+    return len(q.items)
+}
+
+// Due returns the number of pending items that are not scheduled for
+// later: items ready or being processed.
+func (q *Queue) Due() int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return len(q.pending) - len(q.waiting)
+}
+
+// Persistent reports whether pending items survive Close.
+func (q *Queue) Persistent() bool {
+    return q.config.Dir != ""
+}
+
+// Put appends data to the queue and returns the item id.
+func (q *Queue) Put(data []byte) (uint64, error) {
+    return q.PutAfter(data, 0)
+}
+
+// PutAfter appends data to the queue, the item is handed out once
+// delay has passed, also when it is replayed after a restart.
+func (q *Queue) PutAfter(data []byte, delay time.Duration) (uint64, error) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    if q.closed {
+        return 0, ErrClosed
+    }
+    if len(q.pending) >= q.config.MaxItems {
+        return 0, ErrFull
+    }
+    return q.put(data, delay)
+}
+
+// Replace acknowledges the pending item id and appends data in its place,
+// handed out after delay. It is not limited by MaxItems as the new item
+// takes the slot of the old one.
+func (q *Queue) Replace(id uint64, data []byte, delay time.Duration) (uint64, error) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    if q.closed {
+        return 0, ErrClosed
+    }
+    if _, ok := q.pending[id]; !ok && len(q.pending) >= q.config.MaxItems {
+        return 0, ErrFull
+    }
+
+    // The new item is written first, a crash in between replays both
+    newID, err := q.put(data, delay)
+    if err != nil {
+        return 0, err
+    }
+    return newID, q.ack(id)
+}
+
+// put appends an item, the caller holds the lock.
+func (q *Queue) put(data []byte, delay time.Duration) (uint64, error) {
+    id := q.nextID
+    q.nextID++
+
+    item := Item{ID: id, Data: data}
+    if delay > 0 {
+        item.Due = time.Now().Add(delay)
+        record := make([]byte, 8+len(data))
+        binary.BigEndian.PutUint64(record, uint64(item.Due.UnixNano()))
+        copy(record[8:], data)
+        if err := q.write(recordPutDelayed, id, record); err != nil {
+            return 0, err
+        }
+    } else if err := q.write(recordPut, id, data); err != nil {
+        return 0, err
+    }
+    q.pending[id] = q.current
+    if q.current != nil {
+        q.current.pending++
+    }
+
+    q.schedule(item)
+    return id, nil
+}
+
+// PutAll appends all items or none of them: the items are handed out only
+// once every record is written. Records written before a failure are
+// acknowledged again so that they are not replayed.
+func (q *Queue) PutAll(list [][]byte) ([]uint64, error) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    if q.closed {
+        return nil, ErrClosed
+    }
+    if len(q.pending)+len(list) > q.config.MaxItems {
+        return nil, ErrFull
+    }
+
+    ids := make([]uint64, 0, len(list))
+    segments := make([]*segment, 0, len(list))
+    for _, data := range list {
+        id := q.nextID
+        q.nextID++
+        if err := q.write(recordPut, id, data); err != nil {
+            for _, written := range ids {
+                q.write(recordAck, written, nil)
+            }
+            return nil, err
+        }
+        ids = append(ids, id)
+        segments = append(segments, q.current)
+    }
+    for i, id := range ids {
+        q.pending[id] = segments[i]
+        if segments[i] != nil {
+            segments[i].pending++
+        }
+        q.schedule(Item{ID: id, Data: list[i]})
+    }
+    return ids, nil
+}
+
+// schedule hands the item out now or once it is due, the caller holds the lock.
+func (q *Queue) schedule(item Item) {
+    delay := time.Until(item.Due)
+    if delay <= 0 {
+        q.items <- item
+        return
+    }
+    q.waiting[item.ID] = true
+    time.AfterFunc(delay, func() {
+        q.mu.Lock()
+        defer q.mu.Unlock()
+        if _, ok := q.pending[item.ID]; ok && !q.closed {
+            delete(q.waiting, item.ID)
+            q.items <- item
+        }
+    })
+}
+
+// Ack marks the item as processed, it is not replayed after a restart.
+func (q *Queue) Ack(id uint64) error {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return q.ack(id)
+}
+
+// ack acknowledges an item, the caller holds the lock.
+func (q *Queue) ack(id uint64) error {
+    seg, ok := q.pending[id]
+    if !ok {
+        return nil
+    }
+    delete(q.pending, id)
+    delete(q.waiting, id)
+
+    if q.config.Dir == "" {
+        return nil
+    }
+    if q.closed {
+        return ErrClosed
+    }
+
+    if err := q.write(recordAck, id, nil); err != nil {
+        return err
+    }
+    seg.pending--
+    return q.cleanup()
+}
+
+// Close flushes the log and closes the items channel. Items that were not
+// acknowledged are replayed on the next Open.
+func (q *Queue) Close() error {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    if q.closed {
+        return nil
+    }
+    q.closed = true
+    close(q.done)
+    close(q.items)
+
+    if q.current == nil {
+        return nil
+    }
+    if err := q.current.writer.Flush(); err != nil {
+        return err
+    }
+    if err := q.current.file.Sync(); err != nil {
+        return err
+    }
+    return q.current.file.Close()
+}
+
+func (q *Queue) write(kind byte, id uint64, data []byte) error {
+    if q.config.Dir == "" {
+        return nil
+    }
+    if q.current.size >= q.config.SegmentSize {
+        if err := q.rotate(); err != nil {
+            return err
+        }
+    }
+
+    header := make([]byte, headerSize)
+    binary.BigEndian.PutUint32(header[0:4], uint32(len(data)))
+    header[8] = kind
+    binary.BigEndian.PutUint64(header[9:17], id)
+    crc := crc32.ChecksumIEEE(header[8:])
+    crc = crc32.Update(crc, crc32.IEEETable, data)
+    binary.BigEndian.PutUint32(header[4:8], crc)
+
+    w := q.current.writer
+    if _, err := w.Write(header); err != nil {
+        return err
+    }
+    if _, err := w.Write(data); err != nil {
+        return err
+    }
+    if err := w.Flush(); err != nil {
+        return err
+    }
+    q.current.size += int64(headerSize + len(data))
+
+    if q.config.Fsync == "always" {
+        return q.current.file.Sync()
+    }
+    return nil
+}
+
+// rotate starts a new segment file.
+func (q *Queue) rotate() error {
+    var seq uint64 = 1
+    if q.current != nil {
+        seq = q.current.seq + 1
+        if err := q.current.writer.Flush(); err != nil {
+            return err
+        }
+        if q.config.Fsync != "never" {
+            if err := q.current.file.Sync(); err != nil {
+                return err
+            }
+        }
+        if err := q.current.file.Close(); err != nil {
+            return err
+        }
+    } else if n := len(q.segments); n > 0 {
+        seq = q.segments[n-1].seq + 1
+    }
+
+    path := filepath.Join(q.config.Dir, fmt.Sprintf("%016d.wal", seq))
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+
+    q.current = &segment{seq: seq, path: path, file: file, writer: bufio.NewWriter(file)}
+    q.segments = append(q.segments, q.current)
+    return q.cleanup()
+}
+
+// cleanup removes fully acknowledged segments from the head of the log.
+// Only head segments are removed so that acks stored in later segments
+// never outlive the puts they refer to.
+func (q *Queue) cleanup() error {
+    for len(q.segments) > 0 {
+        seg := q.segments[0]
+        if seg == q.current || seg.pending > 0 {
+            return nil
+        }
+        if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+        q.segments = q.segments[1:]
+    }
+    return nil
+}
+
+func (q *Queue) syncLoop() {
+    ticker := time.NewTicker(q.config.FsyncInterval)
+    defer ticker.Stop()
+    for {
+        select {
+            case <-q.done:
+                return
+            case <-ticker.C:
+                q.mu.Lock()
+                if !q.closed && q.current != nil {
+                    if err := q.current.file.Sync(); err != nil {
+                        log.Printf("[error] queue fsync %v", err)
+                    }
+                }
+                q.mu.Unlock()
+        }
+    }
+}
+
+// load reads all segments and returns the items that were not acknowledged.
+func (q *Queue) load() ([]Item, error) {
+    files, err := ioutil.ReadDir(q.config.Dir)
+    if err != nil {
+        return nil, err
+    }
+
+    var names []string
+    for _, f := range files {
+        if strings.HasSuffix(f.Name(), ".wal") {
+            names = append(names, f.Name())
+        }
+    }
+    sort.Strings(names)
+
+    data := map[uint64]Item{}
+    var order []uint64
+
+    for _, name := range names {
+        var seq uint64
+        if _, err := fmt.Sscanf(name, "%016d.wal", &seq); err != nil {
+            continue
+        }
+        seg := &segment{seq: seq, path: filepath.Join(q.config.Dir, name)}
+        q.segments = append(q.segments, seg)
+
+        err := readSegment(seg.path, func(kind byte, id uint64, payload []byte) {
+            if id >= q.nextID {
+                q.nextID = id + 1
+            }
+            switch kind {
+                case recordPut, recordPutDelayed:
+                    item := Item{ID: id, Data: payload}
+                    if kind == recordPutDelayed {
+                        item.Due = time.Unix(0, int64(binary.BigEndian.Uint64(payload[:8])))
+                        item.Data = payload[8:]
+                    }
+                    data[id] = item
+                    order = append(order, id)
+                    q.pending[id] = seg
+                    seg.pending++
+                case recordAck:
+                    if s, ok := q.pending[id]; ok {
+                        s.pending--
+                        delete(q.pending, id)
+                        delete(data, id)
+                    }
+            }
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    var items []Item
+    for _, id := range order {
+        if item, ok := data[id]; ok {
+            items = append(items, item)
+        }
+    }
+    return items, nil
+}
+
+// readSegment calls fn for every valid record. A torn or corrupt record
+// ends the segment, it is what a crash in the middle of a write leaves behind.
+func readSegment(path string, fn func(kind byte, id uint64, data []byte)) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    info, err := file.Stat()
+    if err != nil {
+        return err
+    }
+
+    r := bufio.NewReader(file)
+    header := make([]byte, headerSize)
+    for {
+        if _, err := io.ReadFull(r, header); err != nil {
+            if err != io.EOF {
+                log.Printf("[warn] queue segment %s: truncated record", path)
+            }
+            return nil
+        }
+        size := binary.BigEndian.Uint32(header[0:4])
+        if int64(size) > info.Size() {
+            log.Printf("[warn] queue segment %s: corrupt record", path)
+            return nil
+        }
+        data := make([]byte, size)
+        if _, err := io.ReadFull(r, data); err != nil {
+            log.Printf("[warn] queue segment %s: truncated record", path)
+            return nil
+        }
+        crc := crc32.ChecksumIEEE(header[8:])
+        crc = crc32.Update(crc, crc32.IEEETable, data)
+        if crc != binary.BigEndian.Uint32(header[4:8]) {
+            log.Printf("[warn] queue segment %s: checksum mismatch", path)
+            return nil
+        }
+        kind, id := header[8], binary.BigEndian.Uint64(header[9:17])
+        if (kind == recordAck && size != 0) || (kind == recordPutDelayed && size < 8) {
+            continue
+        }
+        fn(kind, id, data)
+    }
+}
@@ -0,0 +1,268 @@
+package queue
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func open(t *testing.T, dir string, max int) *Queue {
+    t.Helper()
+    q, err := Open(Config{Dir: dir, MaxItems: max, Fsync: "always"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return q
+}
+
+// ready returns the data of the items handed out without waiting.
+func ready(q *Queue) []string {
+    var list []string
+    for {
+        select {
+            case item, ok := <-q.Items():
+                if !ok {
+                    return list
+                }
+                list = append(list, string(item.Data))
+            default:
+                return list
+        }
+    }
+}
+
+func equal(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+func TestReplay(t *testing.T) {
+    tests := []struct {
+        name    string
+        put     []string
+        ack     []int
+        want    []string
+    }{
+        {"nothing acknowledged", []string{"a", "b", "c"}, nil, []string{"a", "b", "c"}},
+        {"some acknowledged", []string{"a", "b", "c"}, []int{0, 2}, []string{"b"}},
+        {"all acknowledged", []string{"a", "b"}, []int{0, 1}, nil},
+        {"empty", nil, nil, nil},
+    }
+    for _, tt := range tests {
+        dir, err := ioutil.TempDir("", "queue")
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer os.RemoveAll(dir)
+
+        q := open(t, dir, 10)
+        var ids []uint64
+        for _, data := range tt.put {
+            id, err := q.Put([]byte(data))
+            if err != nil {
+                t.Fatalf("%s: put %v", tt.name, err)
+            }
+            ids = append(ids, id)
+        }
+        for _, i := range tt.ack {
+            if err := q.Ack(ids[i]); err != nil {
+                t.Fatalf("%s: ack %v", tt.name, err)
+            }
+        }
+        q.Close()
+
+        q = open(t, dir, 10)
+        if got := ready(q); !equal(got, tt.want) {
+            t.Errorf("%s: replayed %v, want %v", tt.name, got, tt.want)
+        }
+        if q.Len() != len(tt.want) {
+            t.Errorf("%s: len %d, want %d", tt.name, q.Len(), len(tt.want))
+        }
+        q.Close()
+    }
+}
+
+func TestReplayCorrupt(t *testing.T) {
+    tests := []struct {
+        name    string
+        damage  func(data []byte) []byte
+        want    []string
+    }{
+        {"intact", func(data []byte) []byte { return data }, []string{"first", "second"}},
+        {"torn last record", func(data []byte) []byte { return data[:len(data)-3] }, []string{"first"}},
+        {"checksum mismatch", func(data []byte) []byte {
+            data[len(data)-1] ^= 0xff
+            return data
+        }, []string{"first"}},
+        {"torn header", func(data []byte) []byte { return data[:headerSize+len("first")+5] }, []string{"first"}},
+    }
+    for _, tt := range tests {
+        dir, err := ioutil.TempDir("", "queue")
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer os.RemoveAll(dir)
+
+        q := open(t, dir, 10)
+        q.Put([]byte("first"))
+        q.Put([]byte("second"))
+        q.Close()
+
+        files, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+        if len(files) != 1 {
+            t.Fatalf("%s: %d segments", tt.name, len(files))
+        }
+        data, err := ioutil.ReadFile(files[0])
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := ioutil.WriteFile(files[0], tt.damage(data), 0644); err != nil {
+            t.Fatal(err)
+        }
+
+        q = open(t, dir, 10)
+        if got := ready(q); !equal(got, tt.want) {
+            t.Errorf("%s: replayed %v, want %v", tt.name, got, tt.want)
+        }
+        q.Close()
+    }
+}
+
+func TestPutAll(t *testing.T) {
+    dir, err := ioutil.TempDir("", "queue")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    q := open(t, dir, 3)
+    if _, err := q.Put([]byte("a")); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := q.PutAll([][]byte{[]byte("b"), []byte("c"), []byte("d")}); err != ErrFull {
+        t.Fatalf("put over capacity: %v, want ErrFull", err)
+    }
+    if q.Len() != 1 {
+        t.Fatalf("len %d after rejected put, want 1", q.Len())
+    }
+    ids, err := q.PutAll([][]byte{[]byte("b"), []byte("c")})
+    if err != nil || len(ids) != 2 {
+        t.Fatalf("put: %v %v", ids, err)
+    }
+    if got := ready(q); !equal(got, []string{"a", "b", "c"}) {
+        t.Errorf("handed out %v", got)
+    }
+    q.Close()
+
+    q = open(t, dir, 3)
+    if got := ready(q); !equal(got, []string{"a", "b", "c"}) {
+        t.Errorf("replayed %v", got)
+    }
+    q.Close()
+}
+
+func TestPutAfter(t *testing.T) {
+    dir, err := ioutil.TempDir("", "queue")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    q := open(t, dir, 10)
+    q.PutAfter([]byte("later"), time.Hour)
+    if got := ready(q); len(got) != 0 {
+        t.Errorf("delayed item handed out: %v", got)
+    }
+    if q.Due() != 0 || q.Len() != 1 {
+        t.Errorf("due %d len %d, want 0 1", q.Due(), q.Len())
+    }
+    q.Close()
+
+    // The due time survives a restart
+    q = open(t, dir, 10)
+    if got := ready(q); len(got) != 0 {
+        t.Errorf("delayed item handed out after replay: %v", got)
+    }
+    q.Close()
+}
+
+func TestSegments(t *testing.T) {
+    dir, err := ioutil.TempDir("", "queue")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    q, err := Open(Config{Dir: dir, MaxItems: 100, SegmentSize: 64, Fsync: "never"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var ids []uint64
+    for i := 0; i < 20; i++ {
+        id, err := q.Put([]byte("0123456789"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        ids = append(ids, id)
+    }
+    for _, id := range ids[:19] {
+        q.Ack(id)
+    }
+    // Head segments with acknowledged items only are removed
+    if _, err := os.Stat(filepath.Join(dir, "0000000000000001.wal")); !os.IsNotExist(err) {
+        t.Errorf("first segment not removed: %v", err)
+    }
+    q.Close()
+
+    q, err = Open(Config{Dir: dir, MaxItems: 100, SegmentSize: 64, Fsync: "never"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := ready(q); len(got) != 1 {
+        t.Errorf("replayed %d items, want 1", len(got))
+    }
+    q.Close()
+}
+
+func TestReplace(t *testing.T) {
+    dir, err := ioutil.TempDir("", "queue")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    q := open(t, dir, 2)
+    a, _ := q.Put([]byte("a"))
+    q.Put([]byte("b"))
+    ready(q)
+
+    // A full queue still takes the retry of a pending item
+    if _, err := q.Replace(a, []byte("a retry"), 0); err != nil {
+        t.Fatalf("replace in a full queue: %v", err)
+    }
+    if q.Len() != 2 {
+        t.Errorf("len %d, want 2", q.Len())
+    }
+    if _, err := q.Replace(12345, []byte("unknown"), 0); err != ErrFull {
+        t.Errorf("replace of an unknown item: %v, want ErrFull", err)
+    }
+    if got := ready(q); !equal(got, []string{"a retry"}) {
+        t.Errorf("handed out %v", got)
+    }
+    q.Close()
+
+    q = open(t, dir, 2)
+    if got := ready(q); !equal(got, []string{"b", "a retry"}) {
+        t.Errorf("replayed %v", got)
+    }
+    q.Close()
+}
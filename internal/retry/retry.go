@@ -0,0 +1,93 @@
+package retry
+
+import (
+    "errors"
+    "math"
+    "math/rand"
+    "net"
+    "time"
+
+    "github.com/ltkh/adapter/internal/breaker"
+    "github.com/ltkh/adapter/internal/soap"
+    "github.com/ltkh/adapter/internal/webhook"
+)
+
+type Policy struct {
+    // Maximum number of attempts including the first one, no retries when <= 1
+    MaxAttempts int
+    // Backoff before the first retry, doubled for every further retry
+    InitialBackoff time.Duration
+    // Upper bound of the backoff
+    MaxBackoff time.Duration
+    // Random fraction (0..1) subtracted from or added to the backoff
+    Jitter float64
+    // No retries once the delivery is older than this, unlimited when zero
+    MaxAge time.Duration
+}
+
+// Retryable classifies err. Network errors, timeouts, 5xx, 408 and 429
+// responses, failed response checks and server side SOAP faults can be
+// retried, as well as deliveries held back by an open circuit breaker.
+// Anything else (template errors, 4xx, ...) fails immediately.
+// The returned duration is the delay requested with Retry-After, if any.
+func Retryable(err error) (bool, time.Duration) {
+    var openErr *breaker.OpenError
+    if errors.As(err, &openErr) {
+        return true, openErr.RetryAfter
+    }
+
+    var statusErr *webhook.StatusError
+    if errors.As(err, &statusErr) {
+        switch {
+            case statusErr.StatusCode == 429, statusErr.StatusCode == 503:
+                return true, statusErr.RetryAfter
+            case statusErr.StatusCode == 408, statusErr.StatusCode >= 500:
+                return true, 0
+        }
+        return false, 0
+    }
+
+    var checkErr *webhook.CheckError
+    if errors.As(err, &checkErr) {
+        return true, 0
+    }
+
+    var fault *soap.Fault
+    if errors.As(err, &fault) {
+        return !fault.ClientFault(), 0
+    }
+
+    var netErr net.Error
+    if errors.As(err, &netErr) {
+        return true, 0
+    }
+
+    return false, 0
+}
+
+// Backoff returns the delay before the given retry (1 for the first retry).
+func (p Policy) Backoff(retry int) time.Duration {
+    backoff := float64(p.InitialBackoff) * math.Pow(2, float64(retry-1))
+    if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+        backoff = float64(p.MaxBackoff)
+    }
+    if p.Jitter > 0 {
+        backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+    }
+    if backoff < 0 {
+        backoff = 0
+    }
+    return time.Duration(backoff)
+}
+
+// Allows reports whether another attempt may follow the given number of
+// attempts for a delivery first received at receivedAt.
+func (p Policy) Allows(attempts int, receivedAt time.Time, delay time.Duration) bool {
+    if attempts >= p.MaxAttempts {
+        return false
+    }
+    if p.MaxAge > 0 && time.Since(receivedAt)+delay > p.MaxAge {
+        return false
+    }
+    return true
+}
@@ -0,0 +1,89 @@
+package retry
+
+import (
+    "errors"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/ltkh/adapter/internal/breaker"
+    "github.com/ltkh/adapter/internal/soap"
+    "github.com/ltkh/adapter/internal/webhook"
+)
+
+func TestRetryable(t *testing.T) {
+    tests := []struct {
+        name       string
+        err        error
+        retryable  bool
+        retryAfter time.Duration
+    }{
+        {"500", &webhook.StatusError{StatusCode: 500}, true, 0},
+        {"503 retry after", &webhook.StatusError{StatusCode: 503, RetryAfter: time.Minute}, true, time.Minute},
+        {"429", &webhook.StatusError{StatusCode: 429}, true, 0},
+        {"408", &webhook.StatusError{StatusCode: 408}, true, 0},
+        {"400", &webhook.StatusError{StatusCode: 400}, false, 0},
+        {"wrapped 502", fmt.Errorf("send: %w", &webhook.StatusError{StatusCode: 502}), true, 0},
+        {"open breaker", &breaker.OpenError{RetryAfter: time.Second}, true, time.Second},
+        {"soap client fault", &soap.Fault{Code: "soap:Client"}, false, 0},
+        {"soap sender fault", &soap.Fault{Code: "soap:Sender"}, false, 0},
+        {"soap server fault", &soap.Fault{Code: "soap:Server"}, true, 0},
+        {"template error", errors.New("template: bad"), false, 0},
+    }
+    for _, tt := range tests {
+        retryable, retryAfter := Retryable(tt.err)
+        if retryable != tt.retryable || retryAfter != tt.retryAfter {
+            t.Errorf("%s: got %v %v, want %v %v", tt.name, retryable, retryAfter, tt.retryable, tt.retryAfter)
+        }
+    }
+}
+
+func TestBackoff(t *testing.T) {
+    p := Policy{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+    tests := []struct {
+        retry int
+        want  time.Duration
+    }{
+        {1, time.Second},
+        {2, 2 * time.Second},
+        {3, 4 * time.Second},
+        {4, 8 * time.Second},
+        {5, 10 * time.Second},
+        {20, 10 * time.Second},
+    }
+    for _, tt := range tests {
+        if got := p.Backoff(tt.retry); got != tt.want {
+            t.Errorf("retry %d: got %v, want %v", tt.retry, got, tt.want)
+        }
+    }
+
+    p.Jitter = 0.5
+    for i := 0; i < 100; i++ {
+        if got := p.Backoff(1); got < 500*time.Millisecond || got > 1500*time.Millisecond {
+            t.Fatalf("jittered backoff %v out of range", got)
+        }
+    }
+}
+
+func TestAllows(t *testing.T) {
+    p := Policy{MaxAttempts: 3, MaxAge: time.Hour}
+    now := time.Now()
+    tests := []struct {
+        name       string
+        attempts   int
+        receivedAt time.Time
+        delay      time.Duration
+        want       bool
+    }{
+        {"first retry", 1, now, time.Second, true},
+        {"last retry", 2, now, time.Second, true},
+        {"attempts used up", 3, now, time.Second, false},
+        {"too old", 1, now.Add(-2 * time.Hour), time.Second, false},
+        {"delay beyond max age", 1, now.Add(-59 * time.Minute), 2 * time.Minute, false},
+    }
+    for _, tt := range tests {
+        if got := p.Allows(tt.attempts, tt.receivedAt, tt.delay); got != tt.want {
+            t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,55 @@
+package route
+
+import (
+    "fmt"
+    "strings"
+)
+
+// CheckPattern reports malformed path patterns such as /alerts/{team/x.
+func CheckPattern(pattern string) error {
+    names := map[string]bool{}
+    for _, seg := range strings.Split(pattern, "/") {
+        open, close := strings.Contains(seg, "{"), strings.Contains(seg, "}")
+        if !open && !close {
+            continue
+        }
+        if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") || len(seg) < 3 {
+            return fmt.Errorf("path %q: a parameter must be a whole segment like {name}", pattern)
+        }
+        name := seg[1 : len(seg)-1]
+        if names[name] {
+            return fmt.Errorf("path %q: duplicate parameter %q", pattern, name)
+        }
+        names[name] = true
+    }
+    return nil
+}
+
+// MatchPath matches path against a pattern like /alerts/{team}/{env} and
+// returns the captured segments. Parameters match one non-empty segment.
+func MatchPath(pattern, path string) (map[string]string, bool) {
+    if !strings.Contains(pattern, "{") {
+        return nil, pattern == path
+    }
+
+    want := strings.Split(pattern, "/")
+    got := strings.Split(path, "/")
+    if len(want) != len(got) {
+        return nil, false
+    }
+
+    params := map[string]string{}
+    for i, seg := range want {
+        if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+            if got[i] == "" {
+                return nil, false
+            }
+            params[seg[1:len(seg)-1]] = got[i]
+            continue
+        }
+        if seg != got[i] {
+            return nil, false
+        }
+    }
+    return params, true
+}
@@ -0,0 +1,54 @@
+package route
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestCheckPattern(t *testing.T) {
+    tests := []struct {
+        pattern string
+        valid   bool
+    }{
+        {"/alerts", true},
+        {"/alerts/{team}", true},
+        {"/alerts/{team}/{env}", true},
+        {"/alerts/{team", false},
+        {"/alerts/team}", false},
+        {"/alerts/x{team}", false},
+        {"/alerts/{}", false},
+        {"/alerts/{a}/{a}", false},
+    }
+    for _, tt := range tests {
+        if err := CheckPattern(tt.pattern); (err == nil) != tt.valid {
+            t.Errorf("%s: %v", tt.pattern, err)
+        }
+    }
+}
+
+func TestMatchPath(t *testing.T) {
+    tests := []struct {
+        pattern string
+        path    string
+        params  map[string]string
+        ok      bool
+    }{
+        {"/alerts", "/alerts", nil, true},
+        {"/alerts", "/alerts/", nil, false},
+        {"/alerts/{team}", "/alerts/db", map[string]string{"team": "db"}, true},
+        {"/alerts/{team}", "/alerts/", nil, false},
+        {"/alerts/{team}", "/alerts/db/x", nil, false},
+        {"/alerts/{team}/{env}", "/alerts/db/prod", map[string]string{"team": "db", "env": "prod"}, true},
+        {"/alerts/{team}/raw", "/alerts/db/json", nil, false},
+    }
+    for _, tt := range tests {
+        params, ok := MatchPath(tt.pattern, tt.path)
+        if ok != tt.ok {
+            t.Errorf("%s %s: matched %v", tt.pattern, tt.path, ok)
+            continue
+        }
+        if ok && tt.params != nil && !reflect.DeepEqual(params, tt.params) {
+            t.Errorf("%s %s: params %v, want %v", tt.pattern, tt.path, params, tt.params)
+        }
+    }
+}
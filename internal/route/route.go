@@ -0,0 +1,222 @@
+package route
+
+import (
+    "encoding/json"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+
+    "github.com/ltkh/adapter/internal/jsonpath"
+)
+
+// Route is a node of a routing tree. A request goes to the receivers of the
+// deepest matching routes: children are tried in order and the first one
+// that matches ends the search unless it sets continue. When no child
+// matches, the route's own receiver is used.
+type Route struct {
+    // Request path the tree is served on, only used on the root route
+    Path                string             `yaml:"path,omitempty" json:"path,omitempty"`
+    // Accepted request methods, only used on the root route
+    Methods             []string           `yaml:"methods,omitempty" json:"methods,omitempty"`
+    // Receiver path, inherited from the parent route when empty
+    Receiver            string             `yaml:"receiver,omitempty" json:"receiver,omitempty"`
+    // All matchers must match, e.g. 'commonLabels.severity="critical"'
+    Matchers            []string           `yaml:"matchers,omitempty" json:"matchers,omitempty"`
+    // Keep trying the following siblings after this route matched
+    Continue            bool               `yaml:"continue,omitempty" json:"continue,omitempty"`
+    Routes              []*Route           `yaml:"routes,omitempty" json:"routes,omitempty"`
+
+    matchers            []*Matcher
+}
+
+// Compile parses the matchers of the tree and fills in inherited receivers.
+func (r *Route) Compile() error {
+    return r.compile("")
+}
+
+func (r *Route) compile(parent string) error {
+    if r.Receiver == "" {
+        r.Receiver = parent
+    }
+    if r.Receiver == "" {
+        return fmt.Errorf("route without receiver")
+    }
+    r.matchers = nil
+    for _, s := range r.Matchers {
+        m, err := ParseMatcher(s)
+        if err != nil {
+            return err
+        }
+        r.matchers = append(r.matchers, m)
+    }
+    for _, child := range r.Routes {
+        if err := child.compile(r.Receiver); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Receivers returns the receivers referenced in the tree.
+func (r *Route) Receivers() []string {
+    list := []string{r.Receiver}
+    for _, child := range r.Routes {
+        list = append(list, child.Receivers()...)
+    }
+    return list
+}
+
+// Match returns the receivers doc is routed to, without duplicates.
+func (r *Route) Match(doc interface{}) []string {
+    var list []string
+    seen := map[string]bool{}
+    for _, receiver := range r.match(doc) {
+        if !seen[receiver] {
+            seen[receiver] = true
+            list = append(list, receiver)
+        }
+    }
+    return list
+}
+
+func (r *Route) match(doc interface{}) []string {
+    for _, m := range r.matchers {
+        if !m.Matches(doc) {
+            return nil
+        }
+    }
+
+    var list []string
+    for _, child := range r.Routes {
+        matched := child.match(doc)
+        list = append(list, matched...)
+        if len(matched) > 0 && !child.Continue {
+            break
+        }
+    }
+    if len(list) == 0 {
+        list = []string{r.Receiver}
+    }
+    return list
+}
+
+// Matcher tests a field of the payload. Supported forms are
+// name="value", name!="value", name=~"regex", name!~"regex",
+// name (the field exists) and !name (the field does not exist).
+// Names are dotted paths (commonLabels.severity) or JSONPath expressions.
+type Matcher struct {
+    expr                string
+    op                  string
+    value               string
+    path                *jsonpath.Path
+    regex               *regexp.Regexp
+}
+
+func ParseMatcher(s string) (*Matcher, error) {
+    m := &Matcher{expr: s}
+    s = strings.TrimSpace(s)
+
+    name := s
+    if i := strings.IndexAny(s, "=!~"); i > 0 {
+        name = strings.TrimSpace(s[:i])
+        rest := s[i:]
+        for _, op := range []string{"=~", "!~", "!=", "="} {
+            if strings.HasPrefix(rest, op) {
+                m.op = op
+                break
+            }
+        }
+        if m.op == "" {
+            return nil, fmt.Errorf("matcher %q: unknown operator", m.expr)
+        }
+        m.value = unquote(strings.TrimSpace(rest[len(m.op):]))
+    } else if strings.HasPrefix(s, "!") {
+        m.op = "!"
+        name = strings.TrimSpace(s[1:])
+    } else {
+        m.op = "exists"
+    }
+    // An operator without a name, e.g. ="x" or !="x"
+    if name == "" || strings.IndexAny(name, "=!~") == 0 {
+        return nil, fmt.Errorf("matcher %q: missing field name", m.expr)
+    }
+
+    if !strings.HasPrefix(name, "$") {
+        name = "$." + name
+    }
+    path, err := jsonpath.Compile(name)
+    if err != nil {
+        return nil, fmt.Errorf("matcher %q: %v", m.expr, err)
+    }
+    m.path = path
+
+    if m.op == "=~" || m.op == "!~" {
+        // Anchored like Alertmanager matchers
+        regex, err := regexp.Compile("^(?:" + m.value + ")$")
+        if err != nil {
+            return nil, fmt.Errorf("matcher %q: %v", m.expr, err)
+        }
+        m.regex = regex
+    }
+    return m, nil
+}
+
+func (m *Matcher) String() string {
+    return m.expr
+}
+
+// Matches reports whether doc satisfies the matcher. A missing field compares
+// as an empty string, with several values (wildcards) one match is enough.
+func (m *Matcher) Matches(doc interface{}) bool {
+    var values []string
+    for _, v := range m.path.Lookup(doc) {
+        if v != nil {
+            values = append(values, text(v))
+        }
+    }
+
+    switch m.op {
+        case "exists":
+            return len(values) > 0
+        case "!":
+            return len(values) == 0
+    }
+
+    if len(values) == 0 {
+        values = []string{""}
+    }
+    found := false
+    for _, v := range values {
+        if m.regex != nil && m.regex.MatchString(v) || m.regex == nil && v == m.value {
+            found = true
+            break
+        }
+    }
+    if m.op == "!=" || m.op == "!~" {
+        return !found
+    }
+    return found
+}
+
+// text returns scalars as is and encodes objects and lists as json,
+// numbers are written without exponent (5000000, not 5e+06).
+func text(v interface{}) string {
+    switch v := v.(type) {
+        case string:
+            return v
+        case float64:
+            return strconv.FormatFloat(v, 'f', -1, 64)
+        case map[string]interface{}, []interface{}:
+            data, _ := json.Marshal(v)
+            return string(data)
+    }
+    return fmt.Sprint(v)
+}
+
+func unquote(s string) string {
+    if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+        return s[1 : len(s)-1]
+    }
+    return s
+}
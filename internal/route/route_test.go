@@ -0,0 +1,123 @@
+package route
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+)
+
+func doc(s string) interface{} {
+    var v interface{}
+    if err := json.Unmarshal([]byte(s), &v); err != nil {
+        panic(err)
+    }
+    return v
+}
+
+func TestParseMatcher(t *testing.T) {
+    tests := []struct {
+        expr    string
+        op      string
+        value   string
+        valid   bool
+    }{
+        {`labels.severity="critical"`, "=", "critical", true},
+        {`labels.severity = 'critical'`, "=", "critical", true},
+        {`labels.severity!="info"`, "!=", "info", true},
+        {`labels.team=~"db|net"`, "=~", "db|net", true},
+        {`labels.team!~"db.*"`, "!~", "db.*", true},
+        {`labels.maintenance`, "exists", "", true},
+        {`!labels.maintenance`, "!", "", true},
+        {`$.alerts[0].status="firing"`, "=", "firing", true},
+        {`labels.severity=`, "=", "", true},
+        {`="critical"`, "", "", false},
+        {`!`, "", "", false},
+        {`!="critical"`, "", "", false},
+        {`labels.team=~"("`, "", "", false},
+        {`labels..team="db"`, "", "", false},
+    }
+    for _, tt := range tests {
+        m, err := ParseMatcher(tt.expr)
+        if (err == nil) != tt.valid {
+            t.Errorf("%s: %v", tt.expr, err)
+            continue
+        }
+        if err == nil && (m.op != tt.op || m.value != tt.value) {
+            t.Errorf("%s: parsed %q %q, want %q %q", tt.expr, m.op, m.value, tt.op, tt.value)
+        }
+    }
+}
+
+func TestMatches(t *testing.T) {
+    d := doc(`{
+        "labels": {"severity": "critical", "team": "db", "count": 5000000, "ratio": 0.5, "up": true},
+        "alerts": [{"status": "firing"}, {"status": "resolved"}],
+        "empty": null
+    }`)
+    tests := []struct {
+        expr    string
+        want    bool
+    }{
+        {`labels.severity="critical"`, true},
+        {`labels.severity="warning"`, false},
+        {`labels.severity!="warning"`, true},
+        {`labels.team=~"db|net"`, true},
+        {`labels.team=~"d"`, false},
+        {`labels.team!~"net"`, true},
+        {`labels.count="5000000"`, true},
+        {`labels.ratio="0.5"`, true},
+        {`labels.up="true"`, true},
+        {`labels.missing=""`, true},
+        {`labels.missing!="x"`, true},
+        {`labels.severity`, true},
+        {`labels.missing`, false},
+        {`empty`, false},
+        {`!labels.missing`, true},
+        {`!labels.team`, false},
+        {`$.alerts[*].status="resolved"`, true},
+        {`$.alerts[*].status!="resolved"`, false},
+        {`labels={"count":5000000,"ratio":0.5,"severity":"critical","team":"db","up":true}`, true},
+    }
+    for _, tt := range tests {
+        m, err := ParseMatcher(tt.expr)
+        if err != nil {
+            t.Fatalf("%s: %v", tt.expr, err)
+        }
+        if got := m.Matches(d); got != tt.want {
+            t.Errorf("%s: %v, want %v", tt.expr, got, tt.want)
+        }
+    }
+}
+
+func TestRouteMatch(t *testing.T) {
+    tree := &Route{
+        Receiver: "/default",
+        Routes: []*Route{
+            {Matchers: []string{`severity="critical"`}, Receiver: "/pager", Continue: true},
+            {Matchers: []string{`team="db"`}, Receiver: "/db", Routes: []*Route{
+                {Matchers: []string{`env="prod"`}, Receiver: "/db-prod"},
+            }},
+            {Matchers: []string{`team=~"db|net"`}, Receiver: "/infra"},
+        },
+    }
+    if err := tree.Compile(); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name    string
+        doc     string
+        want    []string
+    }{
+        {"no match", `{"team": "web"}`, []string{"/default"}},
+        {"first match ends", `{"team": "db"}`, []string{"/db"}},
+        {"deepest route", `{"team": "db", "env": "prod"}`, []string{"/db-prod"}},
+        {"continue", `{"severity": "critical", "team": "net"}`, []string{"/pager", "/infra"}},
+        {"continue only", `{"severity": "critical"}`, []string{"/pager"}},
+    }
+    for _, tt := range tests {
+        if got := tree.Match(doc(tt.doc)); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
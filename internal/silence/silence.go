@@ -0,0 +1,288 @@
+package silence
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/ltkh/adapter/internal/route"
+)
+
+// Silence states.
+const (
+    Pending = "pending"
+    Active  = "active"
+    Expired = "expired"
+)
+
+// retention is how long expired silences are kept.
+const retention = 7 * 24 * time.Hour
+
+// Silence mutes the events matching all matchers between StartsAt and EndsAt.
+type Silence struct {
+    ID              string             `json:"id"`
+    // Receiver path, all receivers when empty
+    Receiver        string             `json:"receiver,omitempty"`
+    // Matchers on payload fields, see routes
+    Matchers        []string           `json:"matchers"`
+    StartsAt        time.Time          `json:"starts_at"`
+    EndsAt          time.Time          `json:"ends_at"`
+    CreatedBy       string             `json:"created_by"`
+    Comment         string             `json:"comment"`
+    CreatedAt       time.Time          `json:"created_at"`
+    // Computed when listed
+    State           string             `json:"state,omitempty"`
+
+    matchers        []*route.Matcher
+}
+
+func (s *Silence) compile() error {
+    if len(s.Matchers) == 0 {
+        return fmt.Errorf("silence without matchers")
+    }
+    s.matchers = nil
+    for _, expr := range s.Matchers {
+        m, err := route.ParseMatcher(expr)
+        if err != nil {
+            return err
+        }
+        s.matchers = append(s.matchers, m)
+    }
+    return nil
+}
+
+func (s *Silence) state(now time.Time) string {
+    switch {
+        case now.Before(s.StartsAt):
+            return Pending
+        case now.Before(s.EndsAt):
+            return Active
+    }
+    return Expired
+}
+
+// Store keeps the silences in memory and in a json file when a path is set.
+type Store struct {
+    mu              sync.Mutex
+    path            string
+    silences        map[string]*Silence
+}
+
+// Open loads the silences from path, a missing file is not an error.
+func Open(path string) (*Store, error) {
+    s := &Store{path: path, silences: map[string]*Silence{}}
+    if path == "" {
+        return s, nil
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return nil, err
+    }
+
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return s, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    var list []*Silence
+    if err := json.Unmarshal(data, &list); err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    for _, sil := range list {
+        if err := sil.compile(); err != nil {
+            return nil, fmt.Errorf("%s: silence %s: %v", path, sil.ID, err)
+        }
+        s.silences[sil.ID] = sil
+    }
+    return s, nil
+}
+
+// Add validates a new silence, assigns its id and saves the store.
+// StartsAt defaults to now, EndsAt must be later than StartsAt.
+func (s *Store) Add(sil *Silence) error {
+    now := time.Now()
+    if sil.StartsAt.IsZero() {
+        sil.StartsAt = now
+    }
+    if !sil.EndsAt.After(sil.StartsAt) {
+        return fmt.Errorf("ends_at must be after starts_at")
+    }
+    if !sil.EndsAt.After(now) {
+        return fmt.Errorf("ends_at is in the past")
+    }
+    if sil.CreatedBy == "" {
+        return fmt.Errorf("created_by is required")
+    }
+    if err := sil.compile(); err != nil {
+        return err
+    }
+
+    b := make([]byte, 8)
+    if _, err := rand.Read(b); err != nil {
+        return err
+    }
+    sil.ID = hex.EncodeToString(b)
+    sil.CreatedAt = now
+    sil.State = ""
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.silences[sil.ID] = sil
+    if err := s.save(); err != nil {
+        delete(s.silences, sil.ID)
+        return err
+    }
+    return nil
+}
+
+// Expire ends a silence now.
+func (s *Store) Expire(id string) (*Silence, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sil, ok := s.silences[id]
+    if !ok {
+        return nil, fmt.Errorf("silence %s not found", id)
+    }
+    now := time.Now()
+    if sil.state(now) == Expired {
+        return nil, fmt.Errorf("silence %s is already expired", id)
+    }
+
+    previous := sil.EndsAt
+    sil.EndsAt = now
+    if sil.StartsAt.After(now) {
+        sil.StartsAt = now
+    }
+    if err := s.save(); err != nil {
+        sil.EndsAt = previous
+        return nil, err
+    }
+    return s.copy(sil, now), nil
+}
+
+// Get returns a copy of a silence.
+func (s *Store) Get(id string) (*Silence, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sil, ok := s.silences[id]
+    if !ok {
+        return nil, false
+    }
+    return s.copy(sil, time.Now()), true
+}
+
+// List returns copies of all silences, the latest ending first.
+func (s *Store) List() []*Silence {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := time.Now()
+    list := make([]*Silence, 0, len(s.silences))
+    for _, sil := range s.silences {
+        list = append(list, s.copy(sil, now))
+    }
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].EndsAt.After(list[j].EndsAt)
+    })
+    return list
+}
+
+// Match returns the active silence muting doc for receiver, if any.
+func (s *Store) Match(receiver string, doc interface{}) (*Silence, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := time.Now()
+    for _, sil := range s.silences {
+        if sil.state(now) != Active {
+            continue
+        }
+        if sil.Receiver != "" && sil.Receiver != receiver {
+            continue
+        }
+        matched := true
+        for _, m := range sil.matchers {
+            if !m.Matches(doc) {
+                matched = false
+                break
+            }
+        }
+        if matched {
+            return s.copy(sil, now), true
+        }
+    }
+    return nil, false
+}
+
+func (s *Store) copy(sil *Silence, now time.Time) *Silence {
+    c := *sil
+    c.Matchers = append([]string(nil), sil.Matchers...)
+    c.State = sil.state(now)
+    return &c
+}
+
+// save drops silences expired longer than the retention and writes the
+// file, the caller holds the lock.
+func (s *Store) save() error {
+    now := time.Now()
+    list := make([]*Silence, 0, len(s.silences))
+    for id, sil := range s.silences {
+        if now.Sub(sil.EndsAt) > retention {
+            delete(s.silences, id)
+            continue
+        }
+        list = append(list, sil)
+    }
+    if s.path == "" {
+        return nil
+    }
+
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].CreatedAt.Before(list[j].CreatedAt)
+    })
+    data, err := json.MarshalIndent(list, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    // Write, sync and rename so that a crash never leaves a partial file
+    tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+    f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    if _, err := f.Write(data); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Close(); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp, s.path); err != nil {
+        return err
+    }
+
+    // The rename itself is durable once the directory is synced
+    dir, err := os.Open(filepath.Dir(s.path))
+    if err != nil {
+        return err
+    }
+    defer dir.Close()
+    return dir.Sync()
+}
@@ -0,0 +1,131 @@
+package silence
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestAdd(t *testing.T) {
+    now := time.Now()
+    tests := []struct {
+        name    string
+        sil     Silence
+        valid   bool
+    }{
+        {"valid", Silence{Matchers: []string{`labels.site="msk1"`}, EndsAt: now.Add(time.Hour), CreatedBy: "me"}, true},
+        {"starts later", Silence{Matchers: []string{`labels.site="msk1"`}, StartsAt: now.Add(time.Hour), EndsAt: now.Add(2 * time.Hour), CreatedBy: "me"}, true},
+        {"no matchers", Silence{EndsAt: now.Add(time.Hour), CreatedBy: "me"}, false},
+        {"bad matcher", Silence{Matchers: []string{`labels.site=~"("`}, EndsAt: now.Add(time.Hour), CreatedBy: "me"}, false},
+        {"ends before start", Silence{Matchers: []string{`labels.site="msk1"`}, StartsAt: now.Add(time.Hour), EndsAt: now.Add(time.Minute), CreatedBy: "me"}, false},
+        {"ends in the past", Silence{Matchers: []string{`labels.site="msk1"`}, StartsAt: now.Add(-time.Hour), EndsAt: now.Add(-time.Minute), CreatedBy: "me"}, false},
+        {"no author", Silence{Matchers: []string{`labels.site="msk1"`}, EndsAt: now.Add(time.Hour)}, false},
+    }
+    for _, tt := range tests {
+        s, _ := Open("")
+        sil := tt.sil
+        err := s.Add(&sil)
+        if (err == nil) != tt.valid {
+            t.Errorf("%s: %v", tt.name, err)
+        }
+        if err == nil && sil.ID == "" {
+            t.Errorf("%s: no id assigned", tt.name)
+        }
+    }
+}
+
+func TestMatch(t *testing.T) {
+    now := time.Now()
+    var doc interface{}
+    json.Unmarshal([]byte(`{"labels": {"site": "msk1", "severity": "warning"}}`), &doc)
+
+    tests := []struct {
+        name     string
+        sil      Silence
+        receiver string
+        want     bool
+    }{
+        {"all matchers match", Silence{Matchers: []string{`labels.site="msk1"`, `labels.severity=~"warn.*"`}}, "/a", true},
+        {"one matcher fails", Silence{Matchers: []string{`labels.site="msk1"`, `labels.severity="critical"`}}, "/a", false},
+        {"same receiver", Silence{Receiver: "/a", Matchers: []string{`labels.site="msk1"`}}, "/a", true},
+        {"other receiver", Silence{Receiver: "/b", Matchers: []string{`labels.site="msk1"`}}, "/a", false},
+        {"pending", Silence{Matchers: []string{`labels.site="msk1"`}, StartsAt: now.Add(time.Hour)}, "/a", false},
+    }
+    for _, tt := range tests {
+        s, _ := Open("")
+        sil := tt.sil
+        sil.CreatedBy = "me"
+        if sil.StartsAt.IsZero() {
+            sil.StartsAt = now.Add(-time.Minute)
+        }
+        sil.EndsAt = sil.StartsAt.Add(2 * time.Hour)
+        if err := s.Add(&sil); err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        if _, ok := s.Match(tt.receiver, doc); ok != tt.want {
+            t.Errorf("%s: matched %v, want %v", tt.name, ok, tt.want)
+        }
+
+        // Expired silences never match
+        if tt.want {
+            if _, err := s.Expire(sil.ID); err != nil {
+                t.Fatalf("%s: expire %v", tt.name, err)
+            }
+            if _, ok := s.Match(tt.receiver, doc); ok {
+                t.Errorf("%s: expired silence matched", tt.name)
+            }
+        }
+    }
+}
+
+func TestPersistence(t *testing.T) {
+    dir, err := ioutil.TempDir("", "silence")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "silences.json")
+
+    s, err := Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    kept := &Silence{Matchers: []string{`site="a"`}, EndsAt: time.Now().Add(time.Hour), CreatedBy: "me"}
+    expired := &Silence{Matchers: []string{`site="b"`}, EndsAt: time.Now().Add(time.Hour), CreatedBy: "me"}
+    for _, sil := range []*Silence{kept, expired} {
+        if err := s.Add(sil); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if _, err := s.Expire(expired.ID); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(filepath.Join(dir, ".silences.json.tmp")); !os.IsNotExist(err) {
+        t.Errorf("temporary file left: %v", err)
+    }
+
+    s, err = Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        id      string
+        state   string
+    }{
+        {kept.ID, Active},
+        {expired.ID, Expired},
+    }
+    for _, tt := range tests {
+        sil, ok := s.Get(tt.id)
+        if !ok {
+            t.Errorf("%s: not loaded", tt.id)
+            continue
+        }
+        if sil.State != tt.state {
+            t.Errorf("%s: state %s, want %s", tt.id, sil.State, tt.state)
+        }
+    }
+}
@@ -4,6 +4,7 @@ import (
     "fmt"
     "strconv"
     "sync/atomic"
+    "time"
 
     "github.com/k-sone/snmpgo"
     "github.com/pkg/errors"
@@ -16,6 +17,10 @@ type Config struct {
     Community string 
     // Retries count for traps
     Retries uint 
+    // Send InformRequest and wait for the acknowledgement instead of a trap
+    Inform bool
+    // Timeout waiting for the inform acknowledgement
+    Timeout time.Duration
 }
 
 type Service struct {
@@ -75,6 +80,7 @@ func (s *Service) loadNewSNMPClient(c Config) error {
         Address:   c.Addr,
         Retries:   uint(c.Retries),
         Community: c.Community,
+        Timeout:   c.Timeout,
     })
     if err != nil {
         return errors.Wrap(err, "invalid SNMP configuration")
@@ -141,6 +147,13 @@ func (s *Service) Trap(trapOid string, dataList []Data) error {
         }
     }
 
+    if s.config().Inform {
+        if err = s.client.InformRequest(varBinds); err != nil {
+            return errors.Wrap(err, "failed to send SNMP inform")
+        }
+        return nil
+    }
+
     if err = s.client.V2Trap(varBinds); err != nil {
         return errors.Wrap(err, "failed to send SNMP trap")
     }
@@ -0,0 +1,237 @@
+package soap
+
+import (
+    "bytes"
+    "crypto/rand"
+    "crypto/sha1"
+    "encoding/base64"
+    "encoding/xml"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/ltkh/adapter/internal/webhook"
+)
+
+const (
+    namespace11     = "http://schemas.xmlsoap.org/soap/envelope/"
+    namespace12     = "http://www.w3.org/2003/05/soap-envelope"
+    namespaceWsse   = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"
+    namespaceWsu    = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+    passwordText    = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordText"
+    passwordDigest  = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest"
+    nonceEncoding   = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary"
+)
+
+type Config struct {
+    // SOAP version: 1.1 (default) or 1.2
+    Version string
+    // SOAPAction of the operation
+    Action string
+    // WS-Security UsernameToken credentials, the header is omitted when empty
+    Username string
+    Password string
+    // Password type: text (default) or digest
+    PasswordType string
+    // Lifetime of the WS-Security timestamp, no timestamp when zero
+    TimestampTTL time.Duration
+    // HTTP request timeout
+    Timeout string
+}
+
+type Client struct {
+    config Config
+    http   *webhook.HTTPClient
+}
+
+// Fault is a SOAP fault returned by the server.
+type Fault struct {
+    Code   string
+    Reason string
+    Detail string
+}
+
+func (f *Fault) Error() string {
+    msg := fmt.Sprintf("soap fault %s: %s", f.Code, f.Reason)
+    if f.Detail != "" {
+        msg += " (" + f.Detail + ")"
+    }
+    return msg
+}
+
+// ClientFault reports whether the fault blames the request (Client in
+// SOAP 1.1, Sender in SOAP 1.2), resending it would fail again.
+func (f *Fault) ClientFault() bool {
+    code := f.Code
+    if i := strings.LastIndex(code, ":"); i >= 0 {
+        code = code[i+1:]
+    }
+    return strings.HasPrefix(code, "Client") || strings.HasPrefix(code, "Sender")
+}
+
+type envelope struct {
+    Body struct {
+        Content []byte `xml:",innerxml"`
+        Fault   *fault `xml:"Fault"`
+    } `xml:"Body"`
+}
+
+type fault struct {
+    // SOAP 1.1
+    FaultCode   string `xml:"faultcode"`
+    FaultString string `xml:"faultstring"`
+    FaultDetail inner  `xml:"detail"`
+    // SOAP 1.2
+    Code        string `xml:"Code>Value"`
+    Reason      string `xml:"Reason>Text"`
+    Detail      inner  `xml:"Detail"`
+}
+
+type inner struct {
+    Content string `xml:",innerxml"`
+}
+
+func ValidVersion(version string) bool {
+    return version == "" || version == "1.1" || version == "1.2"
+}
+
+func NewClient(c Config) *Client {
+    h := &webhook.HTTPClient{
+        Timeout: c.Timeout,
+        Method:  "POST",
+    }
+    if c.Version == "1.2" {
+        h.ContentType = "application/soap+xml; charset=utf-8"
+        if c.Action != "" {
+            h.ContentType += fmt.Sprintf("; action=%q", c.Action)
+        }
+    } else {
+        h.ContentType = "text/xml; charset=utf-8"
+        h.Headers = map[string]string{"SOAPAction": fmt.Sprintf("%q", c.Action)}
+    }
+    return &Client{config: c, http: webhook.NewClient(h)}
+}
+
+// Call wraps body in a SOAP envelope, posts it to url and returns
+// the content of the response Body element.
+func (c *Client) Call(url string, body []byte) ([]byte, error) {
+    if _, err := webhook.FormatBody("xml", body, false); err != nil {
+        return nil, err
+    }
+
+    request, err := Envelope(c.config, body)
+    if err != nil {
+        return nil, err
+    }
+
+    response, err := c.http.HttpRequest(url, request)
+    if err != nil {
+        // Servers answer faults with 500, the fault explains why
+        if serr, ok := err.(*webhook.StatusError); ok {
+            if f := parseFault(serr.Body); f != nil {
+                return nil, f
+            }
+        }
+        return nil, err
+    }
+
+    var env envelope
+    if err := xml.Unmarshal(response, &env); err != nil {
+        return nil, fmt.Errorf("invalid soap response: %v", err)
+    }
+    if f := env.Body.Fault; f != nil {
+        return nil, f.toError()
+    }
+    return bytes.TrimSpace(env.Body.Content), nil
+}
+
+// Escape returns the text of v with the XML special characters escaped,
+// for use in element content and attribute values of a body template.
+func Escape(v interface{}) string {
+    var buf bytes.Buffer
+    xml.EscapeText(&buf, []byte(fmt.Sprint(v)))
+    return buf.String()
+}
+
+// Envelope wraps body into a SOAP envelope with an optional WS-Security header.
+func Envelope(c Config, body []byte) ([]byte, error) {
+    ns := namespace11
+    if c.Version == "1.2" {
+        ns = namespace12
+    }
+
+    var buf bytes.Buffer
+    fmt.Fprintf(&buf, `<soap:Envelope xmlns:soap="%s">`, ns)
+    if c.Username != "" {
+        header, err := security(c, time.Now().UTC())
+        if err != nil {
+            return nil, err
+        }
+        buf.WriteString("<soap:Header>")
+        buf.Write(header)
+        buf.WriteString("</soap:Header>")
+    }
+    buf.WriteString("<soap:Body>")
+    buf.Write(body)
+    buf.WriteString("</soap:Body></soap:Envelope>")
+    return buf.Bytes(), nil
+}
+
+func security(c Config, now time.Time) ([]byte, error) {
+    nonce := make([]byte, 16)
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    created := now.Format(time.RFC3339)
+
+    passType, password := passwordText, c.Password
+    if c.PasswordType == "digest" {
+        // Base64(SHA-1(nonce + created + password))
+        h := sha1.New()
+        h.Write(nonce)
+        h.Write([]byte(created))
+        h.Write([]byte(c.Password))
+        passType, password = passwordDigest, base64.StdEncoding.EncodeToString(h.Sum(nil))
+    }
+
+    var buf bytes.Buffer
+    fmt.Fprintf(&buf, `<wsse:Security xmlns:wsse="%s" xmlns:wsu="%s" soap:mustUnderstand="1">`, namespaceWsse, namespaceWsu)
+    if c.TimestampTTL > 0 {
+        fmt.Fprintf(&buf, `<wsu:Timestamp><wsu:Created>%s</wsu:Created><wsu:Expires>%s</wsu:Expires></wsu:Timestamp>`,
+            created, now.Add(c.TimestampTTL).Format(time.RFC3339))
+    }
+    buf.WriteString("<wsse:UsernameToken>")
+    buf.WriteString("<wsse:Username>")
+    xml.EscapeText(&buf, []byte(c.Username))
+    buf.WriteString("</wsse:Username>")
+    fmt.Fprintf(&buf, `<wsse:Password Type="%s">`, passType)
+    xml.EscapeText(&buf, []byte(password))
+    buf.WriteString("</wsse:Password>")
+    fmt.Fprintf(&buf, `<wsse:Nonce EncodingType="%s">%s</wsse:Nonce>`, nonceEncoding, base64.StdEncoding.EncodeToString(nonce))
+    fmt.Fprintf(&buf, `<wsu:Created>%s</wsu:Created>`, created)
+    buf.WriteString("</wsse:UsernameToken></wsse:Security>")
+    return buf.Bytes(), nil
+}
+
+func parseFault(body []byte) error {
+    var env envelope
+    if err := xml.Unmarshal(body, &env); err != nil || env.Body.Fault == nil {
+        return nil
+    }
+    return env.Body.Fault.toError()
+}
+
+func (f *fault) toError() *Fault {
+    e := &Fault{Code: f.FaultCode, Reason: f.FaultString, Detail: f.FaultDetail.Content}
+    if e.Code == "" {
+        e.Code = f.Code
+    }
+    if e.Reason == "" {
+        e.Reason = f.Reason
+    }
+    if e.Detail == "" {
+        e.Detail = f.Detail.Content
+    }
+    e.Detail = strings.TrimSpace(e.Detail)
+    return e
+}
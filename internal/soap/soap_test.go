@@ -0,0 +1,221 @@
+package soap
+
+import (
+    "crypto/sha1"
+    "encoding/base64"
+    "encoding/xml"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestEnvelope(t *testing.T) {
+    tests := []struct {
+        name      string
+        config    Config
+        namespace string
+        header    bool
+    }{
+        {"default", Config{}, namespace11, false},
+        {"1.1", Config{Version: "1.1"}, namespace11, false},
+        {"1.2", Config{Version: "1.2"}, namespace12, false},
+        {"1.2 security", Config{Version: "1.2", Username: "u", Password: "p"}, namespace12, true},
+    }
+    for _, tt := range tests {
+        data, err := Envelope(tt.config, []byte(`<Ping xmlns="urn:test">a &amp; b</Ping>`))
+        if err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        var env struct {
+            XMLName xml.Name
+            Header  *struct{} `xml:"Header"`
+            Body    struct {
+                Ping string `xml:"urn:test Ping"`
+            } `xml:"Body"`
+        }
+        if err := xml.Unmarshal(data, &env); err != nil {
+            t.Fatalf("%s: %v: %s", tt.name, err, data)
+        }
+        if env.XMLName.Space != tt.namespace || env.XMLName.Local != "Envelope" {
+            t.Errorf("%s: root %v", tt.name, env.XMLName)
+        }
+        if (env.Header != nil) != tt.header {
+            t.Errorf("%s: header %v, want %v", tt.name, env.Header != nil, tt.header)
+        }
+        if env.Body.Ping != "a & b" {
+            t.Errorf("%s: body %q", tt.name, env.Body.Ping)
+        }
+    }
+}
+
+func TestSecurity(t *testing.T) {
+    now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+    tests := []struct {
+        name      string
+        config    Config
+        passType  string
+        timestamp bool
+    }{
+        {"text", Config{Username: "u", Password: "p&w"}, passwordText, false},
+        {"digest", Config{Username: "u", Password: "p&w", PasswordType: "digest"}, passwordDigest, false},
+        {"timestamp", Config{Username: "u", Password: "p&w", TimestampTTL: 5 * time.Minute}, passwordText, true},
+    }
+    for _, tt := range tests {
+        data, err := security(tt.config, now)
+        if err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        // The soap prefix is declared by the envelope
+        data = []byte(strings.Replace(string(data), "<wsse:Security ", `<wsse:Security xmlns:soap="`+namespace11+`" `, 1))
+        var sec struct {
+            Created  string `xml:"Timestamp>Created"`
+            Expires  string `xml:"Timestamp>Expires"`
+            Username string `xml:"UsernameToken>Username"`
+            Password struct {
+                Type  string `xml:"Type,attr"`
+                Value string `xml:",chardata"`
+            } `xml:"UsernameToken>Password"`
+            Nonce    string `xml:"UsernameToken>Nonce"`
+            Token    string `xml:"UsernameToken>Created"`
+        }
+        if err := xml.Unmarshal(data, &sec); err != nil {
+            t.Fatalf("%s: %v: %s", tt.name, err, data)
+        }
+        if sec.Username != "u" || sec.Token != "2026-01-01T00:00:00Z" {
+            t.Errorf("%s: token %+v", tt.name, sec)
+        }
+        if sec.Password.Type != tt.passType {
+            t.Errorf("%s: password type %s", tt.name, sec.Password.Type)
+        }
+
+        want := "p&w"
+        if tt.passType == passwordDigest {
+            nonce, err := base64.StdEncoding.DecodeString(sec.Nonce)
+            if err != nil || len(nonce) != 16 {
+                t.Fatalf("%s: nonce %q: %v", tt.name, sec.Nonce, err)
+            }
+            h := sha1.New()
+            h.Write(nonce)
+            h.Write([]byte(sec.Token))
+            h.Write([]byte("p&w"))
+            want = base64.StdEncoding.EncodeToString(h.Sum(nil))
+        }
+        if sec.Password.Value != want {
+            t.Errorf("%s: password %q, want %q", tt.name, sec.Password.Value, want)
+        }
+
+        if tt.timestamp && (sec.Created != "2026-01-01T00:00:00Z" || sec.Expires != "2026-01-01T00:05:00Z") {
+            t.Errorf("%s: timestamp %s - %s", tt.name, sec.Created, sec.Expires)
+        }
+        if !tt.timestamp && sec.Created != "" {
+            t.Errorf("%s: unexpected timestamp", tt.name)
+        }
+    }
+}
+
+func TestCall(t *testing.T) {
+    const (
+        fault11 = `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><soap:Fault>` +
+            `<faultcode>soap:Client</faultcode><faultstring>bad request</faultstring><detail><code>42</code></detail>` +
+            `</soap:Fault></soap:Body></soap:Envelope>`
+        fault12 = `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body><env:Fault>` +
+            `<env:Code><env:Value>env:Receiver</env:Value></env:Code><env:Reason><env:Text xml:lang="en">busy</env:Text></env:Reason>` +
+            `</env:Fault></env:Body></env:Envelope>`
+        result = `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` +
+            `<Result>ok</Result></soap:Body></soap:Envelope>`
+    )
+
+    tests := []struct {
+        name     string
+        version  string
+        code     int
+        response string
+        want     string
+        fault    *Fault
+        client   bool
+    }{
+        {"result", "1.1", 200, result, "<Result>ok</Result>", nil, false},
+        {"1.1 fault", "1.1", 500, fault11, "", &Fault{Code: "soap:Client", Reason: "bad request", Detail: "<code>42</code>"}, true},
+        {"1.1 fault with 200", "1.1", 200, fault11, "", &Fault{Code: "soap:Client", Reason: "bad request", Detail: "<code>42</code>"}, true},
+        {"1.2 fault", "1.2", 500, fault12, "", &Fault{Code: "env:Receiver", Reason: "busy"}, false},
+    }
+    for _, tt := range tests {
+        var contentType, action string
+        srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            contentType, action = r.Header.Get("Content-Type"), r.Header.Get("SOAPAction")
+            w.WriteHeader(tt.code)
+            w.Write([]byte(tt.response))
+        }))
+        client := NewClient(Config{Version: tt.version, Action: "urn:Ping"})
+        resp, err := client.Call(srv.URL, []byte(`<Ping/>`))
+        srv.Close()
+
+        if tt.version == "1.2" {
+            if contentType != `application/soap+xml; charset=utf-8; action="urn:Ping"` || action != "" {
+                t.Errorf("%s: content type %q, action %q", tt.name, contentType, action)
+            }
+        } else if contentType != "text/xml; charset=utf-8" || action != `"urn:Ping"` {
+            t.Errorf("%s: content type %q, action %q", tt.name, contentType, action)
+        }
+
+        if tt.fault == nil {
+            if err != nil || string(resp) != tt.want {
+                t.Errorf("%s: got %s %v", tt.name, resp, err)
+            }
+            continue
+        }
+        fault, ok := err.(*Fault)
+        if !ok {
+            t.Errorf("%s: got %v, want fault", tt.name, err)
+            continue
+        }
+        if *fault != *tt.fault {
+            t.Errorf("%s: got %+v, want %+v", tt.name, fault, tt.fault)
+        }
+        if fault.ClientFault() != tt.client {
+            t.Errorf("%s: client fault %v", tt.name, fault.ClientFault())
+        }
+    }
+}
+
+func TestClientFault(t *testing.T) {
+    tests := []struct {
+        code    string
+        want    bool
+    }{
+        {"soap:Client", true},
+        {"soap:Client.Authentication", true},
+        {"Client", true},
+        {"env:Sender", true},
+        {"soap:Server", false},
+        {"env:Receiver", false},
+        {"env:VersionMismatch", false},
+        {"", false},
+    }
+    for _, tt := range tests {
+        f := &Fault{Code: tt.code}
+        if got := f.ClientFault(); got != tt.want {
+            t.Errorf("%s: %v, want %v", tt.code, got, tt.want)
+        }
+    }
+}
+
+func TestEscape(t *testing.T) {
+    tests := []struct {
+        value   interface{}
+        want    string
+    }{
+        {"a & b < c", "a &amp; b &lt; c"},
+        {`"quoted" 'single'`, "&#34;quoted&#34; &#39;single&#39;"},
+        {42.5, "42.5"},
+        {"plain", "plain"},
+    }
+    for _, tt := range tests {
+        if got := Escape(tt.value); got != tt.want {
+            t.Errorf("%v: got %q, want %q", tt.value, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,210 @@
+package tracker
+
+import (
+    "container/list"
+    "sync"
+    "time"
+)
+
+// Delivery states of an output.
+const (
+    Pending      = "pending"
+    Retrying     = "retrying"
+    Delivered    = "delivered"
+    // Not sent because of the output conditions
+    Skipped      = "skipped"
+    // Not sent because a silence matched the event
+    Silenced     = "silenced"
+    // Not sent because a firing alert inhibited the event
+    Inhibited    = "inhibited"
+    // Collected into a group, which is notified with a request id of its own
+    Grouped      = "grouped"
+    Failed       = "failed"
+    DeadLettered = "dead-lettered"
+)
+
+// Record is the state of all outputs of an accepted request.
+type Record struct {
+    ID              string             `json:"id"`
+    ReceivedAt      time.Time          `json:"received_at"`
+    Outputs         []*Output          `json:"outputs"`
+}
+
+type Output struct {
+    Receiver        string             `json:"receiver"`
+    Output          string             `json:"output"`
+    State           string             `json:"state"`
+    UpdatedAt       time.Time          `json:"updated_at"`
+    Attempts        []Attempt          `json:"attempts,omitempty"`
+    // Entry id in the dead-letter store
+    DeadLetter      string             `json:"dead_letter,omitempty"`
+    // Id of the silence that muted the output
+    Silence         string             `json:"silence,omitempty"`
+    // Fingerprint of the alert that inhibited the output
+    InhibitedBy     string             `json:"inhibited_by,omitempty"`
+}
+
+type Attempt struct {
+    Time            time.Time          `json:"time"`
+    Error           string             `json:"error"`
+}
+
+// Store keeps the most recent records in memory, the oldest record
+// is dropped once the store is full.
+type Store struct {
+    mu              sync.Mutex
+    max             int
+    records         map[string]*list.Element
+    order           *list.List
+}
+
+func New(max int) *Store {
+    if max <= 0 {
+        max = 10000
+    }
+    return &Store{max: max, records: map[string]*list.Element{}, order: list.New()}
+}
+
+func (s *Store) record(id string, receivedAt time.Time) *Record {
+    if el, ok := s.records[id]; ok {
+        return el.Value.(*Record)
+    }
+    r := &Record{ID: id, ReceivedAt: receivedAt}
+    s.records[id] = s.order.PushBack(r)
+    for s.order.Len() > s.max {
+        oldest := s.order.Front()
+        s.order.Remove(oldest)
+        delete(s.records, oldest.Value.(*Record).ID)
+    }
+    return r
+}
+
+// output returns the output of a record, it is created on first use.
+func (r *Record) output(receiver, output string) *Output {
+    for _, out := range r.Outputs {
+        if out.Receiver == receiver && out.Output == output {
+            return out
+        }
+    }
+    o := &Output{Receiver: receiver, Output: output}
+    r.Outputs = append(r.Outputs, o)
+    return o
+}
+
+// Update sets the state of an output, records and outputs are created
+// on first use so that replayed deliveries are tracked as well.
+func (s *Store) Update(id string, receivedAt time.Time, receiver, output, state string, attempt *Attempt) {
+    if s == nil {
+        return
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    o := s.record(id, receivedAt).output(receiver, output)
+    o.State = state
+    o.UpdatedAt = time.Now()
+    if attempt != nil {
+        o.Attempts = append(o.Attempts, *attempt)
+    }
+}
+
+// Silence marks an output as muted by a silence.
+func (s *Store) Silence(id string, receivedAt time.Time, receiver, output, silence string) {
+    if s == nil {
+        return
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    o := s.record(id, receivedAt).output(receiver, output)
+    o.State = Silenced
+    o.Silence = silence
+    o.UpdatedAt = time.Now()
+}
+
+// Inhibit marks an output as muted by a firing alert.
+func (s *Store) Inhibit(id string, receivedAt time.Time, receiver, output, fingerprint string) {
+    if s == nil {
+        return
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    o := s.record(id, receivedAt).output(receiver, output)
+    o.State = Inhibited
+    o.InhibitedBy = fingerprint
+    o.UpdatedAt = time.Now()
+}
+
+// SetDeadLetter links an output to its dead-letter entry.
+func (s *Store) SetDeadLetter(id, receiver, output, entry string) {
+    if s == nil {
+        return
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    el, ok := s.records[id]
+    if !ok {
+        return
+    }
+    for _, out := range el.Value.(*Record).Outputs {
+        if out.Receiver == receiver && out.Output == output {
+            out.State = DeadLettered
+            out.DeadLetter = entry
+        }
+    }
+}
+
+// Remove drops an output, and the record once it has no outputs left.
+func (s *Store) Remove(id, receiver, output string) {
+    if s == nil {
+        return
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    el, ok := s.records[id]
+    if !ok {
+        return
+    }
+    r := el.Value.(*Record)
+    for i, out := range r.Outputs {
+        if out.Receiver == receiver && out.Output == output {
+            r.Outputs = append(r.Outputs[:i], r.Outputs[i+1:]...)
+            break
+        }
+    }
+    if len(r.Outputs) == 0 {
+        s.order.Remove(el)
+        delete(s.records, id)
+    }
+}
+
+// Get returns a copy of the record.
+func (s *Store) Get(id string) (*Record, bool) {
+    if s == nil {
+        return nil, false
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    el, ok := s.records[id]
+    if !ok {
+        return nil, false
+    }
+    r := *el.Value.(*Record)
+    r.Outputs = make([]*Output, len(el.Value.(*Record).Outputs))
+    for i, out := range el.Value.(*Record).Outputs {
+        o := *out
+        o.Attempts = append([]Attempt(nil), out.Attempts...)
+        r.Outputs[i] = &o
+    }
+    return &r, true
+}
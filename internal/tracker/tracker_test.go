@@ -0,0 +1,55 @@
+package tracker
+
+import (
+    "testing"
+    "time"
+)
+
+func TestStore(t *testing.T) {
+    s := New(2)
+    now := time.Now()
+
+    s.Update("a", now, "/r", "webhook_configs[0]", Pending, nil)
+    s.Update("a", now, "/r", "webhook_configs[1]", Pending, nil)
+    s.Update("a", now, "/r", "webhook_configs[0]", Retrying, &Attempt{Time: now, Error: "500"})
+    s.Update("a", now, "/r", "webhook_configs[0]", Delivered, nil)
+    s.Silence("a", now, "/q", "pipeline", "s1")
+
+    r, ok := s.Get("a")
+    if !ok || len(r.Outputs) != 3 {
+        t.Fatalf("record %+v", r)
+    }
+    if o := r.Outputs[0]; o.State != Delivered || len(o.Attempts) != 1 {
+        t.Errorf("output 0: %+v", o)
+    }
+    if o := r.Outputs[2]; o.State != Silenced || o.Silence != "s1" {
+        t.Errorf("output 2: %+v", o)
+    }
+
+    // Copies are not affected by later updates
+    s.Update("a", now, "/r", "webhook_configs[1]", Failed, nil)
+    if r.Outputs[1].State != Pending {
+        t.Errorf("copy changed to %s", r.Outputs[1].State)
+    }
+
+    s.Remove("a", "/r", "webhook_configs[1]")
+    if r, _ := s.Get("a"); len(r.Outputs) != 2 {
+        t.Errorf("%d outputs after remove", len(r.Outputs))
+    }
+    s.Remove("a", "/r", "webhook_configs[0]")
+    s.Remove("a", "/q", "pipeline")
+    if _, ok := s.Get("a"); ok {
+        t.Errorf("record without outputs kept")
+    }
+
+    // The oldest record is dropped once the store is full
+    for _, id := range []string{"b", "c", "d"} {
+        s.Update(id, now, "/r", "pipeline", Pending, nil)
+    }
+    if _, ok := s.Get("b"); ok {
+        t.Errorf("oldest record kept")
+    }
+    if _, ok := s.Get("d"); !ok {
+        t.Errorf("newest record dropped")
+    }
+}
@@ -0,0 +1,232 @@
+package webhook
+
+import (
+    "bytes"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "text/template"
+)
+
+// BuildBody renders every string leaf of tree as a template against data
+// and serialises the resulting document as JSON. A leaf that consists of
+// a single typed helper call (number, bool, list, dict, value, fromJson, null)
+// is replaced by the typed value instead of a string.
+func BuildBody(tree interface{}, data interface{}) ([]byte, error) {
+    doc, err := buildNode(tree, data, "body")
+    if err != nil {
+        return nil, err
+    }
+    return json.Marshal(doc)
+}
+
+func buildNode(node interface{}, data interface{}, path string) (interface{}, error) {
+    switch n := node.(type) {
+        case string:
+            return buildLeaf(n, data, path)
+        case map[interface{}]interface{}:
+            obj := make(map[string]interface{}, len(n))
+            for k, v := range n {
+                key := fmt.Sprint(k)
+                val, err := buildNode(v, data, path+"."+key)
+                if err != nil {
+                    return nil, err
+                }
+                obj[key] = val
+            }
+            return obj, nil
+        case map[string]interface{}:
+            obj := make(map[string]interface{}, len(n))
+            for key, v := range n {
+                val, err := buildNode(v, data, path+"."+key)
+                if err != nil {
+                    return nil, err
+                }
+                obj[key] = val
+            }
+            return obj, nil
+        case []interface{}:
+            arr := make([]interface{}, len(n))
+            for i, v := range n {
+                val, err := buildNode(v, data, fmt.Sprintf("%s[%d]", path, i))
+                if err != nil {
+                    return nil, err
+                }
+                arr[i] = val
+            }
+            return arr, nil
+    }
+    return node, nil
+}
+
+// placeholders stand for typed values in the rendered text. The prefix is
+// random per render so that payload text can never forge one.
+type placeholders struct {
+    prefix          string
+    values          []interface{}
+}
+
+func newPlaceholders() (*placeholders, error) {
+    b := make([]byte, 8)
+    if _, err := rand.Read(b); err != nil {
+        return nil, err
+    }
+    return &placeholders{prefix: "\x00" + hex.EncodeToString(b) + ":"}, nil
+}
+
+func (p *placeholders) store(v interface{}) string {
+    p.values = append(p.values, v)
+    return fmt.Sprintf("%s%d\x00", p.prefix, len(p.values)-1)
+}
+
+// index returns the value index of a placeholder at the start of s and its length.
+func (p *placeholders) index(s string) (int, int, bool) {
+    if !strings.HasPrefix(s, p.prefix) {
+        return 0, 0, false
+    }
+    end := strings.IndexByte(s[len(p.prefix):], '\x00')
+    if end < 0 {
+        return 0, 0, false
+    }
+    i, err := strconv.Atoi(s[len(p.prefix):len(p.prefix)+end])
+    if err != nil || i < 0 || i >= len(p.values) {
+        return 0, 0, false
+    }
+    return i, len(p.prefix) + end + 1, true
+}
+
+// replace substitutes every placeholder in s, unknown ones stay as text.
+func (p *placeholders) replace(s string, f func(v interface{}) string) string {
+    var b strings.Builder
+    for {
+        start := strings.Index(s, p.prefix)
+        if start < 0 {
+            b.WriteString(s)
+            return b.String()
+        }
+        b.WriteString(s[:start])
+        i, n, ok := p.index(s[start:])
+        if !ok {
+            b.WriteString(p.prefix)
+            s = s[start+len(p.prefix):]
+            continue
+        }
+        b.WriteString(f(p.resolve(p.values[i])))
+        s = s[start+n:]
+    }
+}
+
+// resolve replaces placeholders nested inside typed values, e.g. list (number 1).
+func (p *placeholders) resolve(v interface{}) interface{} {
+    switch n := v.(type) {
+        case string:
+            if i, length, ok := p.index(n); ok && length == len(n) {
+                return p.resolve(p.values[i])
+            }
+        case []interface{}:
+            arr := make([]interface{}, len(n))
+            for i, item := range n {
+                arr[i] = p.resolve(item)
+            }
+            return arr
+        case map[string]interface{}:
+            obj := make(map[string]interface{}, len(n))
+            for key, item := range n {
+                obj[key] = p.resolve(item)
+            }
+            return obj
+    }
+    return v
+}
+
+func buildLeaf(text string, data interface{}, path string) (interface{}, error) {
+    if !strings.Contains(text, "{{") {
+        return text, nil
+    }
+
+    p, err := newPlaceholders()
+    if err != nil {
+        return nil, err
+    }
+    tmpl, err := template.New(path).Funcs(typedFuncs(p.store)).Parse(text)
+    if err != nil {
+        return nil, err
+    }
+
+    var buf bytes.Buffer
+    if err := tmpl.Execute(&buf, data); err != nil {
+        return nil, err
+    }
+    out := buf.String()
+
+    // The whole leaf is a single typed value
+    if _, length, ok := p.index(out); ok && length == len(out) {
+        return p.resolve(out), nil
+    }
+
+    // Typed values embedded in surrounding text are rendered as text
+    return p.replace(out, func(v interface{}) string {
+        if str, ok := v.(string); ok {
+            return str
+        }
+        b, _ := json.Marshal(v)
+        return string(b)
+    }), nil
+}
+
+func typedFuncs(store func(interface{}) string) template.FuncMap {
+    return template.FuncMap{
+        "number": func(v interface{}) (string, error) {
+            s := strings.TrimSpace(fmt.Sprint(v))
+            f, err := strconv.ParseFloat(s, 64)
+            if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+                return "", fmt.Errorf("number: %q is not a number", s)
+            }
+            // Forms like 0x10 or .5 are not json numbers
+            if !json.Valid([]byte(s)) {
+                s = strconv.FormatFloat(f, 'f', -1, 64)
+            }
+            return store(json.Number(s)), nil
+        },
+        "bool": func(v interface{}) (string, error) {
+            if b, ok := v.(bool); ok {
+                return store(b), nil
+            }
+            b, err := strconv.ParseBool(strings.TrimSpace(fmt.Sprint(v)))
+            if err != nil {
+                return "", fmt.Errorf("bool: %q is not a boolean", fmt.Sprint(v))
+            }
+            return store(b), nil
+        },
+        "list": func(v ...interface{}) string {
+            return store(v)
+        },
+        "dict": func(v ...interface{}) (string, error) {
+            if len(v) % 2 != 0 {
+                return "", fmt.Errorf("dict: odd number of arguments")
+            }
+            obj := make(map[string]interface{}, len(v)/2)
+            for i := 0; i < len(v); i += 2 {
+                obj[fmt.Sprint(v[i])] = v[i+1]
+            }
+            return store(obj), nil
+        },
+        "value": func(v interface{}) string {
+            return store(v)
+        },
+        "fromJson": func(s string) (string, error) {
+            var v interface{}
+            if err := json.Unmarshal([]byte(s), &v); err != nil {
+                return "", fmt.Errorf("fromJson: %v", err)
+            }
+            return store(v), nil
+        },
+        "null": func() string {
+            return store(nil)
+        },
+    }
+}
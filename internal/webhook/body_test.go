@@ -0,0 +1,35 @@
+package webhook
+
+import (
+    "testing"
+)
+
+func TestBuildBody(t *testing.T) {
+    tests := []struct {
+        name    string
+        tree    interface{}
+        data    interface{}
+        want    string
+        wantErr bool
+    }{
+        {"text", map[string]interface{}{"a": "x {{ .v }}"}, map[string]interface{}{"v": 1}, `{"a":"x 1"}`, false},
+        {"number", map[string]interface{}{"a": "{{ number .v }}"}, map[string]interface{}{"v": "42"}, `{"a":42}`, false},
+        {"number not json", map[string]interface{}{"a": "{{ number .v }}"}, map[string]interface{}{"v": ".5"}, `{"a":0.5}`, false},
+        {"number nan", map[string]interface{}{"a": "{{ number .v }}"}, map[string]interface{}{"v": "NaN"}, ``, true},
+        {"number inf", map[string]interface{}{"a": "{{ number .v }}"}, map[string]interface{}{"v": "+Inf"}, ``, true},
+        {"nested", map[string]interface{}{"a": "{{ list (number 1) (bool true) }}"}, nil, `{"a":[1,true]}`, false},
+        {"embedded", map[string]interface{}{"a": "n={{ number 2 }}"}, nil, `{"a":"n=2"}`, false},
+        {"forged placeholder", map[string]interface{}{"a": "{{ .t }}"}, map[string]interface{}{"t": "\x005\x00"}, `{"a":"\u00005\u0000"}`, false},
+        {"forged with value", map[string]interface{}{"a": "{{ .t }} {{ number 1 }}"}, map[string]interface{}{"t": "\x009\x00"}, `{"a":"\u00009\u0000 1"}`, false},
+    }
+    for _, tt := range tests {
+        got, err := BuildBody(tt.tree, tt.data)
+        if (err != nil) != tt.wantErr {
+            t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+            continue
+        }
+        if err == nil && string(got) != tt.want {
+            t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,145 @@
+package webhook
+
+import (
+    "encoding/json"
+    "fmt"
+    "regexp"
+    "strconv"
+
+    "github.com/ltkh/adapter/internal/jsonpath"
+)
+
+// ResponseCheck describes when a response counts as a successful delivery.
+type ResponseCheck struct {
+    // Accepted status codes, any 2xx when empty
+    StatusCodes         []int              `yaml:"status_codes,omitempty" json:"status_codes,omitempty"`
+    // The response body must match
+    Match               *BodyMatcher       `yaml:"match,omitempty" json:"match,omitempty"`
+    // The response body must not match
+    NotMatch            *BodyMatcher       `yaml:"not_match,omitempty" json:"not_match,omitempty"`
+}
+
+// BodyMatcher matches a response body. With json_path the selected values
+// are compared, otherwise regex is applied to the whole body.
+type BodyMatcher struct {
+    JSONPath            string             `yaml:"json_path,omitempty" json:"json_path,omitempty"`
+    // Expected value of the selected field
+    Value               *string            `yaml:"value,omitempty" json:"value,omitempty"`
+    Regex               string             `yaml:"regex,omitempty" json:"regex,omitempty"`
+
+    path                *jsonpath.Path
+    regex               *regexp.Regexp
+}
+
+// CheckError is returned when a response fails the configured check.
+type CheckError struct {
+    URL                 string
+    Reason              string
+    Body                []byte
+}
+
+func (e *CheckError) Error() string {
+    return fmt.Sprintf("[error] when writing to [%s] response check failed: %s", e.URL, e.Reason)
+}
+
+// Compile prepares the matchers and reports invalid expressions.
+func (c *ResponseCheck) Compile() error {
+    for _, m := range []*BodyMatcher{c.Match, c.NotMatch} {
+        if m == nil {
+            continue
+        }
+        if m.JSONPath == "" && m.Regex == "" {
+            return fmt.Errorf("response matcher needs json_path or regex")
+        }
+        if m.JSONPath != "" {
+            path, err := jsonpath.Compile(m.JSONPath)
+            if err != nil {
+                return err
+            }
+            m.path = path
+        }
+        if m.Regex != "" {
+            regex, err := regexp.Compile(m.Regex)
+            if err != nil {
+                return err
+            }
+            m.regex = regex
+        }
+    }
+    return nil
+}
+
+func (c *ResponseCheck) acceptStatus(code int) bool {
+    if len(c.StatusCodes) == 0 {
+        return code >= 200 && code < 300
+    }
+    for _, accepted := range c.StatusCodes {
+        if code == accepted {
+            return true
+        }
+    }
+    return false
+}
+
+// verify returns the reason why body fails the check, or an empty string.
+func (c *ResponseCheck) verify(body []byte) string {
+    if c.Match != nil && !c.Match.matches(body) {
+        return fmt.Sprintf("body does not match %s", c.Match)
+    }
+    if c.NotMatch != nil && c.NotMatch.matches(body) {
+        return fmt.Sprintf("body matches %s", c.NotMatch)
+    }
+    return ""
+}
+
+func (m *BodyMatcher) String() string {
+    s := m.JSONPath
+    if m.Value != nil {
+        s += fmt.Sprintf(" == %q", *m.Value)
+    }
+    if m.Regex != "" {
+        if s != "" {
+            s += " "
+        }
+        s += fmt.Sprintf("=~ %q", m.Regex)
+    }
+    return s
+}
+
+func (m *BodyMatcher) matches(body []byte) bool {
+    if m.path == nil {
+        return m.regex.Match(body)
+    }
+
+    var doc interface{}
+    if err := json.Unmarshal(body, &doc); err != nil {
+        return false
+    }
+    for _, v := range m.path.Lookup(doc) {
+        s := text(v)
+        if m.Value != nil && s != *m.Value {
+            continue
+        }
+        if m.regex != nil && !m.regex.MatchString(s) {
+            continue
+        }
+        return true
+    }
+    return false
+}
+
+// text formats a json value for comparison, numbers without exponent.
+func text(v interface{}) string {
+    switch v := v.(type) {
+        case nil:
+            return "null"
+        case string:
+            return v
+        case float64:
+            return strconv.FormatFloat(v, 'f', -1, 64)
+        case map[string]interface{}, []interface{}:
+            data, _ := json.Marshal(v)
+            return string(data)
+    }
+    return fmt.Sprint(v)
+}
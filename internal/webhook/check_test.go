@@ -0,0 +1,34 @@
+package webhook
+
+import (
+    "testing"
+)
+
+func TestBodyMatcher(t *testing.T) {
+    str := func(s string) *string { return &s }
+    tests := []struct {
+        name    string
+        matcher BodyMatcher
+        body    string
+        want    bool
+    }{
+        {"string", BodyMatcher{JSONPath: "$.ok", Value: str("yes")}, `{"ok":"yes"}`, true},
+        {"bool", BodyMatcher{JSONPath: "$.ok", Value: str("true")}, `{"ok":true}`, true},
+        {"large number", BodyMatcher{JSONPath: "$.id", Value: str("1000000")}, `{"id":1000000}`, true},
+        {"fraction", BodyMatcher{JSONPath: "$.v", Value: str("0.25")}, `{"v":0.25}`, true},
+        {"null", BodyMatcher{JSONPath: "$.v", Value: str("null")}, `{"v":null}`, true},
+        {"mismatch", BodyMatcher{JSONPath: "$.id", Value: str("1")}, `{"id":2}`, false},
+        {"regex", BodyMatcher{Regex: `"error"`}, `{"error":"x"}`, true},
+        {"path regex", BodyMatcher{JSONPath: "$.items[*].state", Regex: "^fail"}, `{"items":[{"state":"ok"},{"state":"failed"}]}`, true},
+        {"not json", BodyMatcher{JSONPath: "$.ok", Value: str("true")}, `ok`, false},
+    }
+    for _, tt := range tests {
+        c := &ResponseCheck{Match: &tt.matcher}
+        if err := c.Compile(); err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        if got := tt.matcher.matches([]byte(tt.body)); got != tt.want {
+            t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
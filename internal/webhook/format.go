@@ -0,0 +1,87 @@
+package webhook
+
+import (
+    "bytes"
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "io"
+)
+
+// FormatError reports a rendered body that does not match its declared format.
+type FormatError struct {
+    Format          string
+    Line            int
+    Err             error
+}
+
+func (e *FormatError) Error() string {
+    return fmt.Sprintf("invalid %s body at line %d: %v", e.Format, e.Line, e.Err)
+}
+
+// ContentType returns the Content-Type header value for a body format.
+func ContentType(format string) string {
+    switch format {
+        case "json":
+            return "application/json"
+        case "xml":
+            return "application/xml"
+        case "text":
+            return "text/plain; charset=utf-8"
+    }
+    return ""
+}
+
+// ValidFormat reports whether format is a known body format.
+func ValidFormat(format string) bool {
+    return format == "" || ContentType(format) != ""
+}
+
+// FormatBody checks a rendered body against format and, for json,
+// optionally re-serialises it without insignificant whitespace.
+func FormatBody(format string, body []byte, compact bool) ([]byte, error) {
+    switch format {
+        case "json":
+            var v interface{}
+            if err := json.Unmarshal(body, &v); err != nil {
+                return nil, &FormatError{Format: format, Line: jsonErrorLine(body, err), Err: err}
+            }
+            if compact {
+                var buf bytes.Buffer
+                if err := json.Compact(&buf, body); err != nil {
+                    return nil, &FormatError{Format: format, Line: jsonErrorLine(body, err), Err: err}
+                }
+                return buf.Bytes(), nil
+            }
+        case "xml":
+            decoder := xml.NewDecoder(bytes.NewReader(body))
+            for {
+                _, err := decoder.Token()
+                if err == io.EOF {
+                    break
+                }
+                if err != nil {
+                    line := 0
+                    if serr, ok := err.(*xml.SyntaxError); ok {
+                        line = serr.Line
+                    }
+                    return nil, &FormatError{Format: format, Line: line, Err: err}
+                }
+            }
+    }
+    return body, nil
+}
+
+func jsonErrorLine(body []byte, err error) int {
+    offset := int64(len(body))
+    switch e := err.(type) {
+        case *json.SyntaxError:
+            offset = e.Offset
+        case *json.UnmarshalTypeError:
+            offset = e.Offset
+    }
+    if offset > int64(len(body)) {
+        offset = int64(len(body))
+    }
+    return bytes.Count(body[:offset], []byte("\n")) + 1
+}
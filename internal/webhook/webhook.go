@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"bytes"
 	"fmt"
+	"strconv"
 	"time"
     "net/http"
 )
@@ -11,6 +12,11 @@ import (
 type HTTPClient struct {
     Timeout             string             `toml:"timeout"`
     Method              string             `toml:"method"`
+    ContentType         string             `toml:"content_type"`
+    Headers             map[string]string  `toml:"headers"`
+
+    // Success criteria for responses, any 2xx when nil
+    Check               *ResponseCheck     `toml:"check"`
 
     // HTTP Basic Auth Credentials
     Username            string             `toml:"username"`
@@ -19,6 +25,20 @@ type HTTPClient struct {
     client              *http.Client
 }
 
+// StatusError is returned when the server answers with a non-2xx status.
+// The response body is kept so that callers can extract error details.
+type StatusError struct {
+    URL                 string
+    StatusCode          int
+    Body                []byte
+    // Delay requested with the Retry-After header
+    RetryAfter          time.Duration
+}
+
+func (e *StatusError) Error() string {
+    return fmt.Sprintf("[error] when writing to [%s] received status code: %d", e.URL, e.StatusCode)
+}
+
 func NewClient(h *HTTPClient) *HTTPClient {
 
     // Set default timeout
@@ -50,6 +70,14 @@ func (h *HTTPClient) HttpRequest(url string, data []byte) ([]byte, error) {
         return nil, err
     }
 
+    if h.ContentType != "" {
+        req.Header.Set("Content-Type", h.ContentType)
+    }
+
+    for key, value := range h.Headers {
+        req.Header.Set(key, value)
+    }
+
     if h.Username != "" || h.Password != "" {
         req.SetBasicAuth(h.Username, h.Password)
     }
@@ -62,13 +90,43 @@ func (h *HTTPClient) HttpRequest(url string, data []byte) ([]byte, error) {
 
     body, err := ioutil.ReadAll(resp.Body)
 
-    if resp.StatusCode >= 300 {
-        return nil, fmt.Errorf("[error] when writing to [%s] received status code: %d", url, resp.StatusCode)
+    check := h.Check
+    if check == nil {
+        check = &ResponseCheck{}
+    }
+
+    if !check.acceptStatus(resp.StatusCode) {
+        return nil, &StatusError{
+            URL:        url,
+            StatusCode: resp.StatusCode,
+            Body:       body,
+            RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+        }
     }
 
     if err != nil {
         return nil, fmt.Errorf("[error] when writing to [%s] received error: %v", url, err)
     }
 
+    if reason := check.verify(body); reason != "" {
+        return nil, &CheckError{URL: url, Reason: reason, Body: body}
+    }
+
     return body, nil
 }
+
+// retryAfter parses a Retry-After value given in seconds or as an HTTP date.
+func retryAfter(value string) time.Duration {
+    if value == "" {
+        return 0
+    }
+    if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+        return time.Duration(seconds) * time.Second
+    }
+    if date, err := http.ParseTime(value); err == nil {
+        if d := time.Until(date); d > 0 {
+            return d
+        }
+    }
+    return 0
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/url"
+    "path/filepath"
+    "strings"
+    "sync/atomic"
+    "text/template"
+    "time"
+    "gopkg.in/yaml.v2"
+    "github.com/ltkh/adapter/internal/retry"
+    "github.com/ltkh/adapter/internal/snmptrap"
+    "github.com/ltkh/adapter/internal/soap"
+    "github.com/ltkh/adapter/internal/webhook"
+)
+
+// sendError is a delivery error that happened after rendering, it keeps
+// the rendered body for the dead-letter store.
+type sendError struct {
+    err              error
+    rendered         []byte
+}
+
+func (e *sendError) Error() string {
+    return e.err.Error()
+}
+
+func (e *sendError) Unwrap() error {
+    return e.err
+}
+
+// guard runs call through the circuit breaker of the destination. Only
+// errors that blame the destination (see retry.Retryable) count as failures,
+// other errors say nothing about its health and are not reported.
+func guard(dest string, call func() error) error {
+    b := breakers.Get(dest)
+    if err := b.Allow(); err != nil {
+        return err
+    }
+
+    err := call()
+    if retryable, _ := retry.Retryable(err); err != nil && !retryable {
+        b.Release()
+        return err
+    }
+    b.Report(err)
+    return err
+}
+
+// destination returns the scheme and host of rawurl, breakers are kept per host.
+func destination(rawurl string) string {
+    u, err := url.Parse(rawurl)
+    if err != nil || u.Host == "" {
+        return rawurl
+    }
+    return u.Scheme + "://" + u.Host
+}
+
+// render builds the request body either from the structured body tree
+// or from the option templates.
+func (rcConf *WebhookConfig) render(data interface{}) ([]byte, error) {
+    if rcConf.Body != nil {
+        return webhook.BuildBody(rcConf.Body, data)
+    }
+
+    tmpl, err := template.ParseFiles(rcConf.OptionTemplates...)
+    if err != nil {
+        return nil, err
+    }
+
+    var buf bytes.Buffer
+    if err = tmpl.Execute(&buf, &data); err != nil {
+        return nil, err
+    }
+
+    return webhook.FormatBody(rcConf.BodyFormat, buf.Bytes(), rcConf.CompactBody)
+}
+
+func (rcConf *WebhookConfig) contentType() string {
+    if rcConf.Body != nil {
+        return webhook.ContentType("json")
+    }
+    return webhook.ContentType(rcConf.BodyFormat)
+}
+
+func (rcConf *WebhookConfig) templateNames() []string {
+    if rcConf.Body != nil {
+        return []string{"body"}
+    }
+    return rcConf.OptionTemplates
+}
+
+// send renders the body and posts it, returning the response body.
+func (rcConf *WebhookConfig) send(data interface{}) ([]byte, error) {
+    body, err := rcConf.render(data)
+    if err != nil {
+        return nil, err
+    }
+
+    client := webhook.NewClient(&webhook.HTTPClient{
+        ContentType: rcConf.contentType(),
+        Check:       rcConf.Success,
+    })
+    var resp []byte
+    err = guard(destination(rcConf.URL), func() error {
+        resp, err = client.HttpRequest(rcConf.URL, body)
+        return err
+    })
+    if err != nil {
+        return nil, &sendError{err: err, rendered: body}
+    }
+    return resp, nil
+}
+
+// send renders the trap options and sends one trap per option.
+func (rcConf *SnmpTrapConfig) send(data interface{}) error {
+    timeout, _ := time.ParseDuration(rcConf.Timeout)
+    conf := snmptrap.Config{
+        Addr:      rcConf.Addr,
+        Community: rcConf.Community,
+        Retries:   1,
+        Inform:    rcConf.Inform,
+        Timeout:   timeout,
+    }
+
+    tmpl, err := template.ParseFiles(rcConf.OptionTemplates...)
+    if err != nil {
+        return err
+    }
+
+    var buf bytes.Buffer
+    if err = tmpl.Execute(&buf, &data); err != nil {
+        return err
+    }
+
+    opts := &[]snmptrap.Options{}
+    if err := yaml.UnmarshalStrict(buf.Bytes(), opts); err != nil {
+        return err
+    }
+
+    if len(*opts) == 0 {
+        return nil
+    }
+
+    err = guard("snmp://"+rcConf.Addr, func() error {
+        snmp := snmptrap.NewService(conf)
+        if err := snmp.Open(); err != nil {
+            return err
+        }
+        defer snmp.Close()
+
+        for _, opt := range *opts {
+            if err := snmp.Trap(opt.TrapOid, opt.DataList); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return &sendError{err: err, rendered: buf.Bytes()}
+    }
+    return nil
+}
+
+// soapFuncs are the helpers available in soap templates, which are
+// rendered as text: values must go through xml to keep the body well-formed.
+var soapFuncs = template.FuncMap{
+    // xml escapes a value for element content and attribute values
+    "xml": soap.Escape,
+}
+
+// send renders the body element, calls the service and returns the
+// content of the response body element.
+func (rcConf *SoapConfig) send(data interface{}) ([]byte, error) {
+    if len(rcConf.OptionTemplates) == 0 {
+        return nil, fmt.Errorf("soap %s: no option_templates", rcConf.URL)
+    }
+    tmpl, err := template.New(filepath.Base(rcConf.OptionTemplates[0])).Funcs(soapFuncs).ParseFiles(rcConf.OptionTemplates...)
+    if err != nil {
+        return nil, err
+    }
+
+    var buf bytes.Buffer
+    if err = tmpl.Execute(&buf, &data); err != nil {
+        return nil, err
+    }
+
+    ttl, _ := time.ParseDuration(rcConf.TimestampTTL)
+    client := soap.NewClient(soap.Config{
+        Version:      rcConf.Version,
+        Action:       rcConf.Action,
+        Username:     rcConf.Username,
+        Password:     rcConf.Password,
+        PasswordType: rcConf.PasswordType,
+        TimestampTTL: ttl,
+        Timeout:      rcConf.Timeout,
+    })
+    var resp []byte
+    err = guard(destination(rcConf.URL), func() error {
+        resp, err = client.Call(rcConf.URL, buf.Bytes())
+        return err
+    })
+    if err != nil {
+        return nil, &sendError{err: err, rendered: buf.Bytes()}
+    }
+    return resp, nil
+}
+
+// failoverError is returned when all outputs of a failover group failed.
+// It wraps the first retryable error, or the last one when none is, so the
+// group is retried as long as any of its outputs may recover.
+type failoverError struct {
+    errs             []string
+    err              error
+}
+
+func (e *failoverError) Error() string {
+    return "all failover outputs failed: " + strings.Join(e.errs, "; ")
+}
+
+func (e *failoverError) Unwrap() error {
+    return e.err
+}
+
+// send tries the outputs in turn and returns the result of the first
+// one that succeeds. Outputs skipped by their conditions are passed over.
+func (fc *FailoverConfig) send(data interface{}) (map[string]interface{}, error) {
+    start := 0
+    if fc.Strategy == "round_robin" {
+        start = int((atomic.AddUint32(&fc.next, 1) - 1) % uint32(len(fc.Outputs)))
+    }
+
+    failure := &failoverError{}
+    transient := false
+    for i := range fc.Outputs {
+        n := (start + i) % len(fc.Outputs)
+        member := fc.Outputs[n]
+
+        result, err := member.send(data)
+        if err == errSkipped {
+            continue
+        }
+        if err == nil {
+            if len(failure.errs) > 0 {
+                log.Printf("[warn] failover to %s - %s", member.label(n), strings.Join(failure.errs, "; "))
+            }
+            return result, nil
+        }
+
+        failure.errs = append(failure.errs, fmt.Sprintf("%s: %v", member.label(n), err))
+        if retryable, _ := retry.Retryable(err); retryable && !transient {
+            failure.err, transient = err, true
+        } else if !transient {
+            failure.err = err
+        }
+    }
+    if len(failure.errs) == 0 {
+        return nil, errSkipped
+    }
+    return nil, failure
+}
+
+// outputs returns the number of outputs defined in the wrapper.
+func (o *OutputConfig) outputs() int {
+    n := 0
+    if o.Webhook != nil {
+        n++
+    }
+    if o.SNMPTrap != nil {
+        n++
+    }
+    if o.SOAP != nil {
+        n++
+    }
+    if o.Failover != nil {
+        n++
+    }
+    return n
+}
+
+// label names the output in logs, i is its position in the enclosing list.
+func (o *OutputConfig) label(i int) string {
+    if o.Name != "" {
+        return o.Name
+    }
+    return fmt.Sprintf("outputs[%d]", i)
+}
+
+func (o *OutputConfig) splitBy() string {
+    switch {
+        case o.Webhook != nil:
+            return o.Webhook.SplitBy
+        case o.SNMPTrap != nil:
+            return o.SNMPTrap.SplitBy
+        case o.SOAP != nil:
+            return o.SOAP.SplitBy
+        case o.Failover != nil:
+            return o.Failover.SplitBy
+    }
+    return ""
+}
+
+func (o *OutputConfig) retryConfig() *RetryConfig {
+    switch {
+        case o.Webhook != nil:
+            return o.Webhook.Retry
+        case o.SOAP != nil:
+            return o.SOAP.Retry
+        case o.Failover != nil:
+            return o.Failover.Retry
+    }
+    return o.SNMPTrap.Retry
+}
+
+func (o *OutputConfig) templateNames() []string {
+    if o.Webhook != nil {
+        return o.Webhook.templateNames()
+    }
+    if o.SOAP != nil {
+        return o.SOAP.OptionTemplates
+    }
+    if o.Failover != nil {
+        var names []string
+        for _, member := range o.Failover.Outputs {
+            names = append(names, member.templateNames()...)
+        }
+        return names
+    }
+    return o.SNMPTrap.OptionTemplates
+}
+
+// allOutputs returns every output of the receiver wrapped in an OutputConfig:
+// the *_configs entries, the pipeline steps and the failover groups
+// together with their members.
+func (receiver *Receiver) allOutputs() []*OutputConfig {
+    var list []*OutputConfig
+    for _, rcConf := range receiver.WebhookConfigs {
+        list = append(list, &OutputConfig{Webhook: rcConf})
+    }
+    for _, rcConf := range receiver.SNMPTrapConfigs {
+        list = append(list, &OutputConfig{SNMPTrap: rcConf})
+    }
+    for _, rcConf := range receiver.SOAPConfigs {
+        list = append(list, &OutputConfig{SOAP: rcConf})
+    }
+    var walk func(o *OutputConfig)
+    walk = func(o *OutputConfig) {
+        list = append(list, o)
+        if o.Failover != nil {
+            for _, member := range o.Failover.Outputs {
+                walk(member)
+            }
+        }
+    }
+    for _, step := range receiver.Pipeline {
+        walk(step)
+    }
+    for _, group := range receiver.FailoverConfigs {
+        walk(&OutputConfig{Failover: group})
+    }
+    return list
+}
+
+// send delivers data to the wrapped output and returns the step result
+// that is exposed to later pipeline steps as .steps.<name>.
+func (o *OutputConfig) send(data interface{}) (map[string]interface{}, error) {
+    if skip, err := o.skips(data); err != nil {
+        return nil, err
+    } else if skip {
+        return nil, errSkipped
+    }
+    if o.Failover != nil {
+        return o.Failover.send(data)
+    }
+
+    result := map[string]interface{}{}
+
+    if o.SNMPTrap != nil {
+        return result, o.SNMPTrap.send(data)
+    }
+
+    var body []byte
+    var err error
+    if o.SOAP != nil {
+        body, err = o.SOAP.send(data)
+    } else {
+        body, err = o.Webhook.send(data)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    // Expose json responses as a tree, anything else as plain text
+    var v interface{}
+    if err := json.Unmarshal(body, &v); err != nil {
+        v = string(body)
+    }
+    result["body"] = v
+
+    return result, nil
+}
+
+// runPipeline sends data through the receiver pipeline in order and stops
+// at the first failed step, since later steps may depend on its result.
+// Steps with a result in steps were sent by an earlier attempt and are
+// not sent again, the results of new steps are added.
+func runPipeline(receiver *Receiver, data interface{}, steps map[string]interface{}) error {
+    var ctx interface{}
+    if tc, ok := data.(*templateContext); ok {
+        c := *tc
+        c.Steps = steps
+        ctx = &c
+    } else {
+        m := map[string]interface{}{}
+        if obj, ok := data.(map[string]interface{}); ok {
+            for k, v := range obj {
+                m[k] = v
+            }
+        }
+        m["steps"] = steps
+        ctx = m
+    }
+
+    for _, step := range receiver.Pipeline {
+        if _, ok := steps[step.Name]; ok {
+            continue
+        }
+        result, err := step.send(ctx)
+        if err == errSkipped {
+            continue
+        }
+        if err != nil {
+            return fmt.Errorf("step %q: %w - %v", step.Name, err, step.templateNames())
+        }
+        steps[step.Name] = result
+    }
+    return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+    "encoding/xml"
+    "testing"
+)
+
+func TestSoapTemplate(t *testing.T) {
+    ep := newTestEndpoint(t, 200)
+    rcConf := &SoapConfig{URL: ep.srv.URL, OptionTemplates: []string{"config/soap.tmpl"}}
+    data := interface{}(map[string]interface{}{"title": `Disk <90%> & "full"`, "state": "firing"})
+    if _, err := rcConf.send(data); err == nil {
+        // The endpoint does not answer with an envelope
+        t.Fatal("expected an invalid response")
+    }
+
+    var env struct {
+        Summary string `xml:"Body>CreateIncident>Summary"`
+    }
+    if err := xml.Unmarshal([]byte(ep.requests()[0]), &env); err != nil {
+        t.Fatal(err)
+    }
+    if env.Summary != `Disk <90%> & "full"` {
+        t.Errorf("summary %q", env.Summary)
+    }
+}
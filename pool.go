@@ -0,0 +1,192 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "sync"
+    "sync/atomic"
+    "github.com/ltkh/adapter/internal/queue"
+)
+
+var (
+    // worker pools by receiver path, receivers without own workers share defaultPool
+    pools       map[string]*pool
+    defaultPool *pool
+)
+
+// pool is a bounded set of workers with its own queue depth. Pending counts
+// the deliveries of the pool that are not finished yet, from the moment
+// they are enqueued, it is checked before new requests are accepted.
+// Jobs wait in the backlog of the pool so that a saturated pool never
+// holds up the others.
+type pool struct {
+    name             string
+    depth            int64
+    pending          int64
+
+    mu               sync.Mutex
+    cond             *sync.Cond
+    backlog          []*job
+    // deliveries counted by reserve that were not handed to the pool yet
+    reserved         map[string]int
+    closed           bool
+}
+
+type job struct {
+    item             queue.Item
+    delivery         *delivery
+}
+
+func newPool(name string, workers, depth int, q *queue.Queue) *pool {
+    p := &pool{
+        name:     name,
+        depth:    int64(depth),
+        reserved: map[string]int{},
+    }
+    p.cond = sync.NewCond(&p.mu)
+    for i := 0; i < workers; i++ {
+        go func() {
+            for {
+                j := p.next()
+                if j == nil {
+                    return
+                }
+                process(q, j.item, j.delivery)
+                atomic.AddInt64(&p.pending, -1)
+            }
+        }()
+    }
+    return p
+}
+
+// next waits for a job, nil once the pool is closed and drained.
+func (p *pool) next() *job {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    for len(p.backlog) == 0 && !p.closed {
+        p.cond.Wait()
+    }
+    if len(p.backlog) == 0 {
+        return nil
+    }
+    j := p.backlog[0]
+    p.backlog[0] = nil
+    p.backlog = p.backlog[1:]
+    return j
+}
+
+// reserve counts a delivery that is about to be enqueued.
+func (p *pool) reserve(d *delivery) {
+    if p == nil {
+        return
+    }
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    p.reserved[d.key()]++
+    atomic.AddInt64(&p.pending, 1)
+}
+
+// release undoes reserve for a delivery that could not be enqueued.
+func (p *pool) release(d *delivery) {
+    if p == nil {
+        return
+    }
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if p.take(d.key()) {
+        atomic.AddInt64(&p.pending, -1)
+    }
+}
+
+// take consumes a reservation, the caller holds the lock.
+func (p *pool) take(key string) bool {
+    if p.reserved[key] == 0 {
+        return false
+    }
+    p.reserved[key]--
+    if p.reserved[key] == 0 {
+        delete(p.reserved, key)
+    }
+    return true
+}
+
+// dispatch hands a job to the workers without blocking. Deliveries that
+// were not reserved, e.g. retries and items replayed after a restart,
+// are counted here.
+func (p *pool) dispatch(j *job) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if !p.take(j.delivery.key()) {
+        atomic.AddInt64(&p.pending, 1)
+    }
+    p.backlog = append(p.backlog, j)
+    p.cond.Signal()
+}
+
+func (p *pool) close() {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    p.closed = true
+    p.cond.Broadcast()
+}
+
+// full reports whether n more deliveries would exceed the queue depth.
+func (p *pool) full(n int) bool {
+    return atomic.LoadInt64(&p.pending)+int64(n) > p.depth
+}
+
+// idle reports whether no pool has deliveries waiting or in progress.
+func idle() bool {
+    if atomic.LoadInt64(&defaultPool.pending) > 0 {
+        return false
+    }
+    for _, p := range pools {
+        if atomic.LoadInt64(&p.pending) > 0 {
+            return false
+        }
+    }
+    return true
+}
+
+func poolFor(path string) *pool {
+    if p, ok := pools[path]; ok {
+        return p
+    }
+    return defaultPool
+}
+
+// startPools starts the worker pools and hands queued deliveries to the
+// pool of their receiver until the queue is closed.
+func startPools(q *queue.Queue, c *Config) {
+    defaultPool = newPool("default", c.Global.Queue.Workers, c.Global.Queue.QueueDepth, q)
+    pools = map[string]*pool{}
+    for _, receiver := range c.Receivers {
+        if receiver.Workers > 0 {
+            pools[receiver.Path] = newPool(receiver.Path, receiver.Workers, receiver.QueueDepth, q)
+        }
+    }
+
+    def, all := defaultPool, pools
+    go func() {
+        for item := range q.Items() {
+            d := &delivery{}
+            if err := json.Unmarshal(item.Data, d); err != nil {
+                log.Printf("[error] queue item %d: %v", item.ID, err)
+                q.Ack(item.ID)
+                continue
+            }
+            poolFor(d.Receiver).dispatch(&job{item: item, delivery: d})
+        }
+        def.close()
+        for _, p := range all {
+            p.close()
+        }
+    }()
+}
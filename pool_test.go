@@ -0,0 +1,97 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "github.com/ltkh/adapter/internal/tracker"
+)
+
+func TestPoolReserve(t *testing.T) {
+    p := newPool("test", 0, 2, nil)
+    reserved := &delivery{ID: "1", Receiver: "/a", Output: "webhook_configs[0]"}
+    replayed := &delivery{ID: "2", Receiver: "/a", Output: "webhook_configs[0]"}
+
+    steps := []struct {
+        name    string
+        do      func()
+        pending int64
+        full    bool
+    }{
+        {"reserve", func() { p.reserve(reserved) }, 1, false},
+        // The reservation is taken by the job, it is not counted twice
+        {"dispatch reserved", func() { p.dispatch(&job{delivery: reserved}) }, 1, false},
+        {"dispatch replayed", func() { p.dispatch(&job{delivery: replayed}) }, 2, true},
+        // Nothing is reserved any more
+        {"release", func() { p.release(reserved) }, 2, true},
+        {"reserve again", func() { p.reserve(reserved) }, 3, true},
+        {"release again", func() { p.release(reserved) }, 2, true},
+    }
+    for _, s := range steps {
+        s.do()
+        if n := atomic.LoadInt64(&p.pending); n != s.pending {
+            t.Errorf("%s: %d pending, want %d", s.name, n, s.pending)
+        }
+        if p.full(1) != s.full {
+            t.Errorf("%s: full %v", s.name, !s.full)
+        }
+    }
+}
+
+func TestBackpressure(t *testing.T) {
+    release := make(chan struct{})
+    slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        <-release
+    }))
+    defer slow.Close()
+    fast := newTestEndpoint(t, 200)
+
+    setup(t, `
+global:
+  listen_address: ':0'
+  queue:
+    retry_after: '2s'
+receivers:
+- path: '/slow'
+  workers: 1
+  queue_depth: 2
+  webhook_configs:
+    - url: '`+slow.URL+`'
+      body:
+        text: '{{ .name }}'
+- path: '/fast'
+  webhook_configs:
+    - url: '`+fast.srv.URL+`'
+      body:
+        text: '{{ .name }}'
+`)
+    var ids []string
+    for i := 0; i < 2; i++ {
+        w := post("/slow", `{"name": "x"}`)
+        if w.Code != 202 {
+            t.Fatalf("request %d: code %d", i, w.Code)
+        }
+        ids = append(ids, w.Header().Get("X-Delivery-Id"))
+    }
+
+    // The saturated pool rejects requests, the shared pool still accepts
+    w := post("/slow", `{"name": "x"}`)
+    if w.Code != 429 || w.Header().Get("Retry-After") != "2" {
+        t.Errorf("saturated pool: code %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+    }
+    if w := post("/fast", `{"name": "x"}`); w.Code != 202 {
+        t.Errorf("other pool: code %d", w.Code)
+    }
+
+    close(release)
+    for _, id := range ids {
+        waitFor(t, "delivery "+id, func() bool {
+            return state(id, "/slow", "webhook_configs[0]") == tracker.Delivered
+        })
+    }
+    waitFor(t, "idle pools", idle)
+    if w := post("/slow", `{"name": "x"}`); w.Code != 202 {
+        t.Errorf("drained pool: code %d", w.Code)
+    }
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "sort"
+    "github.com/ltkh/adapter/internal/route"
+)
+
+// endpoint is what a request path resolves to: the receivers configured
+// with the path and the routing trees served on it.
+type endpoint struct {
+    receivers        []*Receiver
+    trees            []*route.Route
+    // Values of the path parameters, e.g. team for /alerts/{team}
+    params           map[string]string
+}
+
+// resolve returns the endpoint of a request. When nothing accepts the
+// method, the endpoint is nil and allowed lists the methods served on the
+// path, which is empty for unknown paths.
+func resolve(path, method string) (*endpoint, []string) {
+    ep := &endpoint{params: map[string]string{}}
+    methods := map[string]bool{}
+
+    // Receivers and routing trees without methods accept any method
+    accept := func(list []string, params map[string]string) bool {
+        ok := len(list) == 0
+        for _, m := range list {
+            methods[m] = true
+            if m == method {
+                ok = true
+            }
+        }
+        if ok {
+            for k, v := range params {
+                ep.params[k] = v
+            }
+        }
+        return ok
+    }
+
+    for _, receiver := range cfg.Receivers {
+        if params, ok := route.MatchPath(receiver.Path, path); ok && accept(receiver.Methods, params) {
+            ep.receivers = append(ep.receivers, receiver)
+        }
+    }
+    for _, tree := range cfg.Routes {
+        if params, ok := route.MatchPath(tree.Path, path); ok && accept(tree.Methods, params) {
+            ep.trees = append(ep.trees, tree)
+        }
+    }
+
+    if len(ep.receivers) == 0 && len(ep.trees) == 0 {
+        var allowed []string
+        for m := range methods {
+            allowed = append(allowed, m)
+        }
+        sort.Strings(allowed)
+        return nil, allowed
+    }
+    return ep, nil
+}
+
+// route returns the receivers of a request: the receivers configured with
+// its path followed by the receivers chosen by the routing trees.
+func (ep *endpoint) route(data interface{}) []*Receiver {
+    receivers := append([]*Receiver{}, ep.receivers...)
+    seen := map[string]bool{}
+    for _, receiver := range receivers {
+        seen[receiver.Path] = true
+    }
+
+    for _, tree := range ep.trees {
+        for _, name := range tree.Match(data) {
+            if seen[name] {
+                continue
+            }
+            seen[name] = true
+            for _, receiver := range cfg.Receivers {
+                if receiver.Path == name {
+                    receivers = append(receivers, receiver)
+                }
+            }
+        }
+    }
+    return receivers
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "text/tabwriter"
+    "time"
+    "github.com/ltkh/adapter/internal/silence"
+)
+
+var (
+    silences    *silence.Store
+)
+
+const silenceUsage = `usage: adapter silence <command> [flags]
+
+commands:
+  list                   list silences
+  add <matcher>...       create a silence, e.g. 'labels.site="msk1"'
+  expire <id>...         end silences now
+
+The api is reached with -url (and -token), or through the admin
+settings of the -config file. One of them is required.
+`
+
+// silenceCommand implements "adapter silence ..." through the api of a
+// running adapter and returns the exit status.
+func silenceCommand(args []string) int {
+    if len(args) == 0 {
+        fmt.Fprint(os.Stderr, silenceUsage)
+        return 2
+    }
+
+    fs := flag.NewFlagSet("silence "+args[0], flag.ContinueOnError)
+    cfFile   := fs.String("config", "", "config file, used to find the api address")
+    url      := fs.String("url", "", "adapter address, e.g. http://127.0.0.1:8085")
+    token    := fs.String("token", os.Getenv("ADAPTER_ADMIN_TOKEN"), "admin api token, read from the config by default")
+    receiver := fs.String("receiver", "", "only silence this receiver path")
+    author   := fs.String("author", os.Getenv("USER"), "author of the silence")
+    comment  := fs.String("comment", "", "reason of the silence")
+    start    := fs.String("start", "", "start time in RFC3339, now by default")
+    end      := fs.String("end", "", "end time in RFC3339")
+    duration := fs.Duration("duration", time.Hour, "duration, unless -end is set")
+    if err := fs.Parse(args[1:]); err != nil {
+        return 2
+    }
+    if *url == "" && *cfFile == "" {
+        fmt.Fprint(os.Stderr, "[error] -url or -config is required\n\n"+silenceUsage)
+        return 2
+    }
+
+    api := &silenceAPI{url: *url, token: *token}
+    if api.url == "" || api.token == "" && *cfFile != "" {
+        c, err := loadConfig(*cfFile)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "[error] %v\n", err)
+            return 1
+        }
+        if api.url == "" {
+            api.url = apiURL(c.Global.adminAddress())
+        }
+        if api.token == "" {
+            api.token = c.Global.AdminToken
+        }
+    }
+    api.url = strings.TrimRight(api.url, "/") + "/api/v1/silences"
+
+    var err error
+    switch args[0] {
+        case "list":
+            err = api.list()
+        case "add":
+            if fs.NArg() == 0 {
+                fmt.Fprint(os.Stderr, silenceUsage)
+                return 2
+            }
+            sil := &silence.Silence{
+                Receiver:  *receiver,
+                Matchers:  fs.Args(),
+                CreatedBy: *author,
+                Comment:   *comment,
+            }
+            if sil.StartsAt, err = parseTime(*start, time.Now()); err != nil {
+                break
+            }
+            if sil.EndsAt, err = parseTime(*end, sil.StartsAt.Add(*duration)); err != nil {
+                break
+            }
+            err = api.add(sil)
+        case "expire":
+            if fs.NArg() == 0 {
+                fmt.Fprint(os.Stderr, silenceUsage)
+                return 2
+            }
+            for _, id := range fs.Args() {
+                if err = api.expire(id); err != nil {
+                    break
+                }
+                fmt.Printf("%s expired\n", id)
+            }
+        default:
+            fmt.Fprint(os.Stderr, silenceUsage)
+            return 2
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "[error] %v\n", err)
+        return 1
+    }
+    return 0
+}
+
+// apiURL returns the local address of the api served on addr.
+func apiURL(addr string) string {
+    host, port, err := net.SplitHostPort(addr)
+    if err != nil {
+        return "http://" + addr
+    }
+    if host == "" || host == "0.0.0.0" || host == "::" {
+        host = "127.0.0.1"
+    }
+    return "http://" + net.JoinHostPort(host, port)
+}
+
+func parseTime(value string, def time.Time) (time.Time, error) {
+    if value == "" {
+        return def, nil
+    }
+    return time.Parse(time.RFC3339, value)
+}
+
+// silenceAPI is the silences api of a running adapter.
+type silenceAPI struct {
+    url              string
+    token            string
+}
+
+// call sends a request to the silences api and decodes the response into v.
+func (api *silenceAPI) call(method, url string, body interface{}, v interface{}) error {
+    var payload []byte
+    if body != nil {
+        var err error
+        if payload, err = json.Marshal(body); err != nil {
+            return err
+        }
+    }
+
+    req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if api.token != "" {
+        req.Header.Set("Authorization", "Bearer "+api.token)
+    }
+    resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    data, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return err
+    }
+    if resp.StatusCode >= 300 {
+        var e struct{ Error string `json:"error"` }
+        if json.Unmarshal(data, &e) == nil && e.Error != "" {
+            return fmt.Errorf("%s", e.Error)
+        }
+        return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+    }
+    return json.Unmarshal(data, v)
+}
+
+func (api *silenceAPI) list() error {
+    var list []*silence.Silence
+    if err := api.call("GET", api.url, nil, &list); err != nil {
+        return err
+    }
+
+    w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(w, "ID\tSTATE\tSTARTS AT\tENDS AT\tRECEIVER\tMATCHERS\tCREATED BY\tCOMMENT")
+    for _, sil := range list {
+        receiver := sil.Receiver
+        if receiver == "" {
+            receiver = "*"
+        }
+        fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+            sil.ID, sil.State, sil.StartsAt.Format(time.RFC3339), sil.EndsAt.Format(time.RFC3339),
+            receiver, strings.Join(sil.Matchers, " "), sil.CreatedBy, sil.Comment)
+    }
+    return w.Flush()
+}
+
+func (api *silenceAPI) add(sil *silence.Silence) error {
+    var resp struct{ ID string `json:"id"` }
+    if err := api.call("POST", api.url, sil, &resp); err != nil {
+        return err
+    }
+    fmt.Println(resp.ID)
+    return nil
+}
+
+func (api *silenceAPI) expire(id string) error {
+    var sil silence.Silence
+    return api.call("DELETE", api.url+"/"+id, nil, &sil)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestSilenceCommand(t *testing.T) {
+    api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(`[]`))
+    }))
+    defer api.Close()
+
+    tests := []struct {
+        name    string
+        args    []string
+        code    int
+    }{
+        {"no command", nil, 2},
+        {"no address", []string{"list"}, 2},
+        {"url", []string{"list", "-url", api.URL}, 0},
+        {"missing config", []string{"list", "-config", "missing.yml"}, 1},
+        {"unknown command", []string{"mute", "-url", api.URL}, 2},
+        {"add without matchers", []string{"add", "-url", api.URL}, 2},
+    }
+    for _, tt := range tests {
+        if code := silenceCommand(tt.args); code != tt.code {
+            t.Errorf("%s: exit %d, want %d", tt.name, code, tt.code)
+        }
+    }
+}
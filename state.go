@@ -0,0 +1,96 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "text/template"
+    "time"
+    "github.com/ltkh/adapter/internal/alertstate"
+)
+
+func (sc *StateConfig) compile() error {
+    if sc.Fingerprint == "" {
+        return fmt.Errorf("fingerprint is required")
+    }
+    if sc.Status == "" {
+        sc.Status = "{{ .status }}"
+    }
+    if sc.TTL == "" {
+        sc.TTL = "24h"
+    }
+    for _, d := range []string{sc.ReminderInterval, sc.TTL} {
+        if d == "" {
+            continue
+        }
+        if _, err := time.ParseDuration(d); err != nil {
+            return err
+        }
+    }
+
+    var err error
+    if sc.fingerprint, err = template.New("fingerprint").Funcs(keyFuncs).Option("missingkey=zero").Parse(sc.Fingerprint); err != nil {
+        return err
+    }
+    if sc.status, err = template.New("status").Funcs(keyFuncs).Option("missingkey=zero").Parse(sc.Status); err != nil {
+        return err
+    }
+    sc.store = alertstate.New()
+    return nil
+}
+
+func (sc *StateConfig) policy() alertstate.Policy {
+    p := alertstate.Policy{SuppressUnknownResolved: sc.SuppressUnknownResolved}
+    p.ReminderInterval, _ = time.ParseDuration(sc.ReminderInterval)
+    p.TTL, _ = time.ParseDuration(sc.TTL)
+    return p
+}
+
+// alert renders the fingerprint and the normalized state of an alert.
+func (receiver *Receiver) alert(data interface{}) (string, string, error) {
+    sc := receiver.State
+    fingerprint, err := renderKey([]*template.Template{sc.fingerprint}, data)
+    if err != nil {
+        return "", "", fmt.Errorf("alert fingerprint %v", err)
+    }
+    status, err := renderKey([]*template.Template{sc.status}, data)
+    if err != nil {
+        return "", "", fmt.Errorf("alert status %v", err)
+    }
+    return fingerprint, alertstate.Normalize(status), nil
+}
+
+// observe records the alert state of a request and reports whether the
+// receiver forwards it. Undo restores the previous state when the request
+// is not accepted, it is nil when nothing was recorded.
+func (receiver *Receiver) observe(data interface{}, receivedAt time.Time) (forward bool, undo func()) {
+    sc := receiver.State
+    fingerprint, state, err := receiver.alert(data)
+    if err != nil {
+        log.Printf("[error] %v - %s", err, receiver.Path)
+        return true, nil
+    }
+
+    forward, reason, prev := sc.store.Observe(receiver.Path, fingerprint, state, receivedAt, sc.policy())
+    if !forward {
+        log.Printf("[info] alert %q %s (%s), not forwarded - %s", fingerprint, state, reason, receiver.Path)
+    }
+    return forward, func() {
+        sc.store.Restore(fingerprint, prev)
+    }
+}
+
+// record updates the alert state of a request that is muted, undo
+// restores the previous state like for observe.
+func (receiver *Receiver) record(data interface{}, receivedAt time.Time) (undo func()) {
+    sc := receiver.State
+    fingerprint, state, err := receiver.alert(data)
+    if err != nil {
+        log.Printf("[error] %v - %s", err, receiver.Path)
+        return nil
+    }
+
+    prev := sc.store.Record(receiver.Path, fingerprint, state, receivedAt, sc.policy())
+    return func() {
+        sc.store.Restore(fingerprint, prev)
+    }
+}
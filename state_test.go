@@ -0,0 +1,42 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "gopkg.in/yaml.v2"
+)
+
+func TestStateSplitBy(t *testing.T) {
+    tests := []struct {
+        name    string
+        conf    string
+    }{
+        {"receiver", `
+receivers:
+- path: '/a'
+  split_by: '$.alerts[*]'
+  state:
+    fingerprint: '{{ .groupKey }}'
+  webhook_configs:
+    - url: 'http://localhost'
+`},
+        {"output", `
+receivers:
+- path: '/a'
+  state:
+    fingerprint: '{{ .groupKey }}'
+  snmptrap_configs:
+    - addr: 'localhost:162'
+      split_by: '$.alerts[*]'
+`},
+    }
+    for _, tt := range tests {
+        c := &Config{}
+        if err := yaml.UnmarshalStrict([]byte(tt.conf), c); err != nil {
+            t.Fatal(err)
+        }
+        if err := c.validate(); err == nil || !strings.Contains(err.Error(), "state and split_by") {
+            t.Errorf("%s: %v", tt.name, err)
+        }
+    }
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+    "net/http"
+    "sync"
+    "time"
+    "github.com/ltkh/adapter/internal/tracker"
+)
+
+// outputResult is the outcome of one output reported to synchronous callers.
+type outputResult struct {
+    Receiver         string             `json:"receiver"`
+    Output           string             `json:"output"`
+    Status           string             `json:"status"`
+    LatencyMs        int64              `json:"latency_ms"`
+    Error            string             `json:"error,omitempty"`
+}
+
+// waiters holds the result channels of synchronous requests by delivery key.
+var waiters = struct {
+    sync.Mutex
+    m map[string]chan *outputResult
+}{m: map[string]chan *outputResult{}}
+
+func (d *delivery) key() string {
+    return d.ID + " " + d.Receiver + " " + d.Output
+}
+
+// notify reports the final outcome of a delivery to a waiting caller, if any.
+func notify(d *delivery, status string, err error) {
+    waiters.Lock()
+    ch, ok := waiters.m[d.key()]
+    delete(waiters.m, d.key())
+    waiters.Unlock()
+    if !ok {
+        return
+    }
+
+    res := &outputResult{
+        Receiver:  d.Receiver,
+        Output:    d.Output,
+        Status:    status,
+        LatencyMs: time.Since(d.ReceivedAt).Nanoseconds() / int64(time.Millisecond),
+    }
+    if err != nil {
+        res.Error = err.Error()
+    }
+    ch <- res
+}
+
+// syncRequested reports whether the caller waits for the delivery results.
+func syncRequested(r *http.Request, receivers []*Receiver) bool {
+    switch r.URL.Query().Get("sync") {
+        case "1", "true":
+            return true
+        case "0", "false":
+            return false
+    }
+    for _, receiver := range receivers {
+        if receiver.Sync {
+            return true
+        }
+    }
+    return false
+}
+
+// syncTimeout returns the longest sync timeout of the receivers (30s by default).
+func syncTimeout(receivers []*Receiver) time.Duration {
+    var timeout time.Duration
+    for _, receiver := range receivers {
+        d, err := time.ParseDuration(receiver.SyncTimeout)
+        if err != nil {
+            d = 30 * time.Second
+        }
+        if d > timeout {
+            timeout = d
+        }
+    }
+    return timeout
+}
+
+// syncWait collects the results of the outputs of one synchronous request.
+type syncWait struct {
+    id               string
+    receivedAt       time.Time
+    pending          []*delivery
+    results          chan *outputResult
+}
+
+// waitResults registers the outputs of the receivers under id,
+// it must be called before the deliveries are enqueued.
+func waitResults(id string, receivers []*Receiver, receivedAt time.Time) *syncWait {
+    sw := &syncWait{id: id, receivedAt: receivedAt}
+    for _, receiver := range receivers {
+        for _, t := range receiver.targets() {
+            sw.pending = append(sw.pending, &delivery{ID: id, Receiver: receiver.Path, Output: t.key, ReceivedAt: receivedAt})
+        }
+    }
+    // Every output reports at most once, notify never blocks
+    sw.results = make(chan *outputResult, len(sw.pending))
+
+    waiters.Lock()
+    for _, d := range sw.pending {
+        waiters.m[d.key()] = sw.results
+    }
+    waiters.Unlock()
+    return sw
+}
+
+// cancel unregisters the outputs, e.g. when the request was not accepted.
+func (sw *syncWait) cancel() {
+    waiters.Lock()
+    for _, d := range sw.pending {
+        delete(waiters.m, d.key())
+    }
+    waiters.Unlock()
+}
+
+// respond blocks until every output finished or the timeout expired and
+// answers 200 when all outputs were delivered, 202 when the others are still
+// pending, 502 when all failed and 207 otherwise.
+func (sw *syncWait) respond(w http.ResponseWriter, timeout time.Duration) {
+    results := map[string]*outputResult{}
+    deadline := time.NewTimer(timeout)
+    defer deadline.Stop()
+
+wait:
+    for len(results) < len(sw.pending) {
+        select {
+            case res := <-sw.results:
+                results[res.Receiver+" "+res.Output] = res
+            case <-deadline.C:
+                break wait
+        }
+    }
+    sw.cancel()
+
+    // Outputs still in progress or waiting for a retry are pending
+    list := []*outputResult{}
+    succeeded, failed := 0, 0
+    for _, d := range sw.pending {
+        res, ok := results[d.Receiver+" "+d.Output]
+        if !ok {
+            res = &outputResult{
+                Receiver:  d.Receiver,
+                Output:    d.Output,
+                Status:    tracker.Pending,
+                LatencyMs: time.Since(sw.receivedAt).Nanoseconds() / int64(time.Millisecond),
+            }
+        }
+        switch res.Status {
+            case tracker.Delivered, tracker.Skipped:
+                succeeded++
+            case tracker.Failed:
+                failed++
+        }
+        list = append(list, res)
+    }
+
+    code := 207
+    switch {
+        case succeeded == len(list):
+            code = 200
+        case failed == 0:
+            code = 202
+        case failed == len(list):
+            code = 502
+    }
+    writeJSON(w, code, map[string]interface{}{"id": sw.id, "outputs": list})
+}
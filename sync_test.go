@@ -0,0 +1,53 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http/httptest"
+    "testing"
+    "time"
+    "github.com/ltkh/adapter/internal/tracker"
+)
+
+func TestSyncRespond(t *testing.T) {
+    tests := []struct {
+        name     string
+        statuses []string
+        code     int
+    }{
+        {"all delivered", []string{tracker.Delivered, tracker.Skipped}, 200},
+        {"still pending", []string{tracker.Delivered, ""}, 202},
+        {"all pending", []string{"", ""}, 202},
+        {"partly failed", []string{tracker.Delivered, tracker.Failed}, 207},
+        {"failed and pending", []string{tracker.Failed, ""}, 207},
+        {"all failed", []string{tracker.Failed, tracker.Failed}, 502},
+    }
+    for _, tt := range tests {
+        sw := &syncWait{id: "1", receivedAt: time.Now(), results: make(chan *outputResult, len(tt.statuses))}
+        for i, status := range tt.statuses {
+            d := &delivery{ID: "1", Receiver: "/a", Output: string(rune('a' + i))}
+            sw.pending = append(sw.pending, d)
+            // An empty status is an output that did not finish in time
+            if status != "" {
+                sw.results <- &outputResult{Receiver: d.Receiver, Output: d.Output, Status: status}
+            }
+        }
+
+        w := httptest.NewRecorder()
+        sw.respond(w, 10*time.Millisecond)
+        if w.Code != tt.code {
+            t.Errorf("%s: code %d, want %d", tt.name, w.Code, tt.code)
+        }
+        var resp struct{ Outputs []*outputResult }
+        if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Outputs) != len(tt.statuses) {
+            t.Fatalf("%s: %s %v", tt.name, w.Body, err)
+        }
+        for i, status := range tt.statuses {
+            if status == "" {
+                status = tracker.Pending
+            }
+            if resp.Outputs[i].Status != status {
+                t.Errorf("%s: output %d %s, want %s", tt.name, i, resp.Outputs[i].Status, status)
+            }
+        }
+    }
+}